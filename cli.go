@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"raidquaza/gymdb"
+)
+
+const defaultGymPath = "gymdb/gyms.txt"
+
+// offline maintenance subcommands; with no arguments we run the bot
+func runCLI(args []string) int {
+	switch args[0] {
+	case "import":
+		return importCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "usage: %s [import|export] ...\n", os.Args[0])
+	return 2
+}
+
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	gymPath := fs.String("gyms", defaultGymPath, "gym database to import into")
+	format := fs.String("format", "", "csv, geojson or gpx (default: from file extension)")
+	columns := fs.String("columns", "", "CSV column headers, e.g. name=Title,lat=Y,lon=X,addr=Address")
+	apply := fs.Bool("apply", false, "apply the changes; otherwise just print the diff")
+	dupes := fs.Bool("dupes", false, "with -apply, also add possible duplicates as new gyms")
+	opts := gymdb.DefaultImportOptions
+	fs.Float64Var(&opts.DuplicateDistance, "distance", opts.DuplicateDistance,
+		"meters within which a new gym may be a duplicate")
+	fs.Float64Var(&opts.DuplicateSimilarity, "similarity", opts.DuplicateSimilarity,
+		"name similarity (0-1) for a possible duplicate")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s import [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	fname := fs.Arg(0)
+
+	if *format == "" {
+		var err error
+		*format, err = gymdb.FormatFromFilename(fname)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+	cols, err := gymdb.ParseCSVColumns(*columns)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer f.Close()
+	gyms, err := gymdb.ReadGymFile(f, *format, cols)
+	if err != nil {
+		log.Printf("%s: %s", fname, err)
+		return 1
+	}
+
+	db := gymdb.NewGymDB(*gymPath)
+	diff := db.DiffImport(gyms, opts)
+	diff.WriteTo(os.Stdout)
+	if !*apply {
+		return 0
+	}
+	if diff.Empty() && !(*dupes && len(diff.Duplicates) > 0) {
+		fmt.Println("nothing to apply.")
+		return 0
+	}
+	err = db.ApplyImport(diff, *dupes)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	fmt.Printf("updated %s.\n", *gymPath)
+	return 0
+}
+
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	gymPath := fs.String("gyms", defaultGymPath, "gym database to export")
+	format := fs.String("format", "", "csv, geojson, kml or gpx (default: from file extension)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s export [flags] <file|->\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	fname := fs.Arg(0)
+
+	if *format == "" {
+		var err error
+		*format, err = gymdb.FormatFromFilename(fname)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+
+	db := gymdb.NewGymDB(*gymPath)
+	out := os.Stdout
+	if fname != "-" {
+		f, err := os.Create(fname)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	err := db.Export(out, *format)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package gymdb
+
+import "raidquaza/s2"
+
+// CountCellLevel is the S2 level whose gym counts are included in exports;
+// level 14 cells decide how many gyms an area can have
+const CountCellLevel = 14
+
+// GymsInCell returns the enabled gyms inside an S2 cell of any level, sorted
+// like SortedGyms
+func (g *GymDB) GymsInCell(cell s2.CellID) []*Gym {
+	var gyms []*Gym
+	for _, gym := range g.SortedGyms() {
+		if gym.Enabled && cell.Contains(gym.CellID(s2.MaxLevel)) {
+			gyms = append(gyms, gym)
+		}
+	}
+	return gyms
+}
+
+// cellCounts counts enabled gyms per S2 cell at the given level
+func cellCounts(gyms []*Gym, level int) map[s2.CellID]int {
+	counts := make(map[s2.CellID]int)
+	for _, gym := range gyms {
+		if gym.Enabled {
+			counts[gym.CellID(level)]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,30 @@
+package gymdb
+
+import "testing"
+
+func TestGymDB_GymsInCell(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	denker := g.GetGymById("00000002")
+	if denker.S2Cell == "" {
+		t.Fatal("cell not computed on load")
+	}
+	cell := denker.CellID(CountCellLevel)
+	if gs := g.GymsInCell(cell); len(gs) != 1 || gs[0] != denker {
+		t.Fatal(gs)
+	}
+	// the smallest cell holding both test gyms
+	valleyTrails := g.GetGymById("00000001")
+	level := CountCellLevel
+	for denker.CellID(level) != valleyTrails.CellID(level) {
+		level--
+	}
+	t.Log("common cell level", level)
+	if gs := g.GymsInCell(denker.CellID(level)); len(gs) != 2 {
+		t.Fatal(gs)
+	}
+	if cellCounts(g.SortedGyms(), level)[denker.CellID(level)] != 2 {
+		t.Fail()
+	}
+}
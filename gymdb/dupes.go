@@ -0,0 +1,110 @@
+package gymdb
+
+import (
+	"errors"
+	"sort"
+
+	"raidquaza/util"
+)
+
+// FindDuplicates clusters gyms that are within maxDistance meters of another
+// gym in the cluster with a NameSimilarity of at least minSimilarity. Only
+// clusters of two or more gyms are returned, each sorted like SortedGyms.
+func (g *GymDB) FindDuplicates(maxDistance, minSimilarity float64) [][]*Gym {
+	gyms := g.SortedGyms()
+
+	// union-find over every close, similarly named pair
+	parent := make([]int, len(gyms))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range gyms {
+		for j := i + 1; j < len(gyms); j++ {
+			a, b := gyms[i], gyms[j]
+			if util.Distance(a.Latitude, a.Longitude, b.Latitude, b.Longitude) > maxDistance {
+				continue
+			}
+			if NameSimilarity(a.Name, b.Name) < minSimilarity {
+				continue
+			}
+			parent[find(j)] = find(i)
+		}
+	}
+
+	clusterOf := make(map[int][]*Gym)
+	for i, gym := range gyms {
+		root := find(i)
+		clusterOf[root] = append(clusterOf[root], gym)
+	}
+	var clusters [][]*Gym
+	for _, c := range clusterOf {
+		if len(c) > 1 {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0].Name < clusters[j][0].Name ||
+			(clusters[i][0].Name == clusters[j][0].Name && clusters[i][0].Id < clusters[j][0].Id)
+	})
+	return clusters
+}
+
+// MergeGyms folds drop into keep: drop's name and aliases become aliases of
+// keep so searches for either still find it, its tags and other metadata fill
+// in anything keep lacks, and drop is removed from the DB.
+func (g *GymDB) MergeGyms(keep, drop *Gym) error {
+	if keep == drop || keep.Id == drop.Id {
+		return errors.New("can't merge a gym into itself")
+	}
+	keepKey, dropKey := keep.SearchKey(), drop.SearchKey()
+	if _, ok := g.Gyms[keepKey]; !ok {
+		return errors.New("can't find gym in DB")
+	}
+	if _, ok := g.Gyms[dropKey]; !ok {
+		return errors.New("can't find gym in DB")
+	}
+	delete(g.Gyms, keepKey)
+	delete(g.Gyms, dropKey)
+
+	for _, alias := range append([]string{drop.Name}, drop.Aliases...) {
+		known := alias == keep.Name
+		for _, a := range keep.Aliases {
+			known = known || a == alias
+		}
+		if !known {
+			keep.Aliases = append(keep.Aliases, alias)
+		}
+	}
+	if keep.ImageUrl == "" {
+		keep.ImageUrl = drop.ImageUrl
+	}
+	if keep.StreetAddr == "" {
+		keep.StreetAddr = drop.StreetAddr
+	}
+	keep.ExEligible = keep.ExEligible || drop.ExEligible
+	for _, tag := range drop.Tags {
+		keep.Tags = appendUnique(keep.Tags, tag)
+	}
+	if keep.Notes == "" {
+		keep.Notes = drop.Notes
+	}
+	for k, v := range drop.Attributes {
+		if _, ok := keep.Attributes[k]; !ok {
+			if keep.Attributes == nil {
+				keep.Attributes = make(map[string]string)
+			}
+			keep.Attributes[k] = v
+		}
+	}
+
+	g.Gyms[keep.SearchKey()] = keep
+	g.UpdateSearchDB()
+	return g.UpdateDiskDB()
+}
@@ -0,0 +1,34 @@
+package gymdb
+
+import "testing"
+
+func TestGymDB_FindDuplicates(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	gazebo := &Gym{Id: "00000003", Name: "Denker Park Gazebo", Latitude: 37.6602, Longitude: -121.8862}
+	g.Gyms[gazebo.SearchKey()] = gazebo
+	g.UpdateSearchDB()
+
+	dupes := g.FindDuplicates(50, 0.5)
+	if len(dupes) != 1 || len(dupes[0]) != 2 {
+		t.Fatal(dupes)
+	}
+	t.Log(dupes[0])
+
+	denker := g.GetGymById("00000002")
+	err := g.MergeGyms(denker, gazebo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Gyms) != 2 || len(denker.Aliases) != 1 {
+		t.Fatal(g.Gyms)
+	}
+	gs, _ := g.GetGyms("gazebo", 1.0)
+	if len(gs) != 1 || gs[0] != denker {
+		t.Fatal("alias not searchable")
+	}
+	if len(g.FindDuplicates(50, 0.5)) != 0 {
+		t.Fail()
+	}
+}
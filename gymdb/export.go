@@ -0,0 +1,216 @@
+package gymdb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Export writes every gym in the DB to w in the given format
+func (g *GymDB) Export(w io.Writer, format string) error {
+	return WriteGymFile(w, format, g.SortedGyms())
+}
+
+// WriteGymFile writes gyms in any of the export formats
+func WriteGymFile(w io.Writer, format string, gyms []*Gym) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, gyms)
+	case FormatGeoJSON:
+		return WriteGeoJSON(w, gyms)
+	case FormatKML:
+		return WriteKML(w, gyms)
+	case FormatGPX:
+		return WriteGPX(w, gyms)
+	}
+	return fmt.Errorf("unknown gym file format %s", format)
+}
+
+// column/property names for each gym's level CountCellLevel cell and the
+// number of enabled gyms in it
+var (
+	countCellKey  = fmt.Sprintf("cell%d", CountCellLevel)
+	countCountKey = fmt.Sprintf("cell%d_gyms", CountCellLevel)
+)
+
+// WriteCSV writes gyms with a header row of DefaultCSVColumns, so the result
+// can be imported back with default settings.
+func WriteCSV(w io.Writer, gyms []*Gym) error {
+	cols := DefaultCSVColumns
+	counts := cellCounts(gyms, CountCellLevel)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{cols.Id, cols.Name, cols.Latitude, cols.Longitude,
+		cols.StreetAddr, cols.ImageUrl, cols.Enabled, cols.ExEligible, cols.Tags,
+		countCellKey, countCountKey})
+	for _, gym := range gyms {
+		cell := gym.CellID(CountCellLevel)
+		cw.Write([]string{
+			gym.Id,
+			gym.Name,
+			strconv.FormatFloat(gym.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(gym.Longitude, 'f', -1, 64),
+			gym.StreetAddr,
+			gym.ImageUrl,
+			strconv.FormatBool(gym.Enabled),
+			strconv.FormatBool(gym.ExEligible),
+			strings.Join(gym.Tags, " "),
+			cell.ToToken(),
+			strconv.Itoa(counts[cell]),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGeoJSON writes gyms as a FeatureCollection of Points
+func WriteGeoJSON(w io.Writer, gyms []*Gym) error {
+	type geometry struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   geometry               `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	data := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	counts := cellCounts(gyms, CountCellLevel)
+	for _, gym := range gyms {
+		cell := gym.CellID(CountCellLevel)
+		props := map[string]interface{}{
+			"gym_id":       gym.Id,
+			"gym_name":     gym.Name,
+			"street_addr":  gym.StreetAddr,
+			"url":          gym.ImageUrl,
+			"enabled":      gym.Enabled,
+			MetaExEligible: gym.ExEligible,
+			MetaTags:       append([]string{}, gym.Tags...),
+			countCellKey:   cell.ToToken(),
+			countCountKey:  counts[cell],
+		}
+		if gym.S2Cell != "" {
+			props[MetaS2Cell] = gym.S2Cell
+		}
+		if gym.Notes != "" {
+			props[MetaNotes] = gym.Notes
+		}
+		if len(gym.Attributes) > 0 {
+			props["attributes"] = gym.Attributes
+		}
+		data.Features = append(data.Features, feature{
+			Type: "Feature",
+			Geometry: geometry{
+				Type:        "Point",
+				Coordinates: []float64{gym.Longitude, gym.Latitude},
+			},
+			Properties: props,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(&data)
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Id          string    `xml:"id,attr,omitempty"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"Point>coordinates"`
+}
+
+// WriteKML writes gyms as a KML document of Placemarks, with the gym fields
+// as ExtendedData.
+func WriteKML(w io.Writer, gyms []*Gym) error {
+	type document struct {
+		XMLName    xml.Name       `xml:"kml"`
+		Xmlns      string         `xml:"xmlns,attr"`
+		Name       string         `xml:"Document>name"`
+		Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+	}
+	doc := document{Xmlns: "http://www.opengis.net/kml/2.2", Name: "Gyms"}
+	counts := cellCounts(gyms, CountCellLevel)
+	for _, gym := range gyms {
+		cell := gym.CellID(CountCellLevel)
+		data := []kmlData{
+			{"gym_id", gym.Id},
+			{"street_addr", gym.StreetAddr},
+			{"url", gym.ImageUrl},
+			{"enabled", strconv.FormatBool(gym.Enabled)},
+		}
+		for _, kv := range gym.Metadata() {
+			data = append(data, kmlData{kv[0], kv[1]})
+		}
+		data = append(data, kmlData{countCellKey, cell.ToToken()},
+			kmlData{countCountKey, strconv.Itoa(counts[cell])})
+		doc.Placemarks = append(doc.Placemarks, kmlPlacemark{
+			Id:          gym.Id,
+			Name:        gym.Name,
+			Description: gym.StreetAddr,
+			Data:        data,
+			Coordinates: fmt.Sprintf("%s,%s,0",
+				strconv.FormatFloat(gym.Longitude, 'f', -1, 64),
+				strconv.FormatFloat(gym.Latitude, 'f', -1, 64)),
+		})
+	}
+	return writeXML(w, &doc)
+}
+
+// WriteGPX writes gyms as GPX waypoints, with the id, enabled flag, EX
+// eligibility and tags as extensions that ReadGPX understands.
+func WriteGPX(w io.Writer, gyms []*Gym) error {
+	type document struct {
+		XMLName   xml.Name      `xml:"gpx"`
+		Xmlns     string        `xml:"xmlns,attr"`
+		Version   string        `xml:"version,attr"`
+		Creator   string        `xml:"creator,attr"`
+		Waypoints []gpxWaypoint `xml:"wpt"`
+	}
+	doc := document{Xmlns: "http://www.topografix.com/GPX/1/1", Version: "1.1", Creator: "raidquaza"}
+	for _, gym := range gyms {
+		wpt := gpxWaypoint{
+			Latitude:  gym.Latitude,
+			Longitude: gym.Longitude,
+			Name:      gym.Name,
+			Desc:      gym.StreetAddr,
+			GymId:     gym.Id,
+			Enabled:   strconv.FormatBool(gym.Enabled),
+			Tags:      strings.Join(gym.Tags, " "),
+		}
+		if gym.ExEligible {
+			wpt.ExEligible = "true"
+		}
+		if gym.ImageUrl != "" {
+			wpt.Link = &gpxLink{Href: gym.ImageUrl}
+		}
+		doc.Waypoints = append(doc.Waypoints, wpt)
+	}
+	return writeXML(w, &doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,39 @@
+package gymdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGymDB_Export(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	// everything but KML should import back unchanged
+	for _, format := range []string{FormatCSV, FormatGeoJSON, FormatGPX} {
+		buf := bytes.NewBuffer(nil)
+		err := g.Export(buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gyms, err := ReadGymFile(buf, format, DefaultCSVColumns)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		diff := g.DiffImport(gyms, DefaultImportOptions)
+		if !diff.Empty() || len(diff.Duplicates) != 0 || diff.Unchanged != len(g.Gyms) {
+			t.Fatal(format, " round trip changed gyms")
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := g.Export(buf, FormatKML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(buf.String())
+	if !strings.Contains(buf.String(), "<coordinates>-121.8862,37.6601,0</coordinates>") {
+		t.Fail()
+	}
+}
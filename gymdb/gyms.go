@@ -14,22 +14,36 @@ import (
 	"io"
 	"sort"
 	"errors"
+	"raidquaza/s2"
+	"raidquaza/store"
+	"bytes"
+	"io/ioutil"
 )
 
 type Gym struct {
-	Id         string  `json:"gym_id"`
-	Name       string  `json:"gym_name"`
-	Latitude   float64 `json:"latitude"`
-	Longitude  float64 `json:"longitude"`
-	ImageUrl   string  `json:"url"`
-	StreetAddr string  `json:"street_addr"`
-	Enabled    bool    `json:"enabled"`
+	Id         string            `json:"gym_id"`
+	Name       string            `json:"gym_name"`
+	Latitude   float64           `json:"latitude"`
+	Longitude  float64           `json:"longitude"`
+	ImageUrl   string            `json:"url"`
+	StreetAddr string            `json:"street_addr"`
+	Enabled    bool              `json:"enabled"`
+	Aliases    []string          `json:"aliases,omitempty"` // names of gyms merged into this one
+	ExEligible bool              `json:"ex_eligible,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`    // e.g. park, sponsored
+	S2Cell     string            `json:"s2_cell,omitempty"` // level 20 S2 cell token
+	Notes      string            `json:"notes,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
+const S2CellLevel = 20 // level of the cell stored with each gym
+
 type GymDB struct {
 	Gyms     map[string]*Gym // map of gym full name -> gym itself
 	Matcher  *closestmatch.ClosestMatch
 	Filename string
+
+	version int // schema version the file was loaded from
 }
 
 // we need to fix the apostrophes in the names and in the queries so that
@@ -52,12 +66,32 @@ func genId() string {
 }
 
 func (g *Gym) String() string {
-	return fmt.Sprintf("[gym %s] (%0.7f,%0.7f) %s | %s",
-		g.Id, g.Latitude, g.Longitude, g.Name, g.StreetAddr)
+	disabled := ""
+	if !g.Enabled {
+		disabled = " (disabled)"
+	}
+	return fmt.Sprintf("[gym %s] (%0.7f,%0.7f) %s | %s%s",
+		g.Id, g.Latitude, g.Longitude, g.Name, g.StreetAddr, disabled)
+}
+
+// CellID returns the level S2 cell containing the gym
+func (g *Gym) CellID(level int) s2.CellID {
+	return s2.CellIDFromLatLng(g.Latitude, g.Longitude).Parent(level)
+}
+
+func (g *Gym) updateCell() {
+	g.S2Cell = g.CellID(S2CellLevel).ToToken()
 }
 
 func (g *Gym) SearchKey() string {
-	return g.Id + " " + strings.ToLower(g.Name) + " " + strings.ToLower(g.StreetAddr)
+	key := g.Id + " " + strings.ToLower(g.Name) + " " + strings.ToLower(g.StreetAddr)
+	for _, alias := range g.Aliases {
+		key += " " + strings.ToLower(alias)
+	}
+	for _, tag := range g.Tags {
+		key += " " + tag
+	}
+	return key
 }
 
 func NewGymDB(gymfile string) *GymDB {
@@ -79,7 +113,34 @@ func NewGymDB(gymfile string) *GymDB {
 }
 
 func (g *GymDB) LoadGyms(r io.Reader) error {
-	scanner := bufio.NewScanner(r)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// the header line with the schema version; files without one are v1
+	g.version = 1
+	if len(data) == 0 {
+		g.version = gymsSchema.Version
+	} else {
+		lines := bytes.SplitN(data, []byte("\n"), 2)
+		env, ok, err := gymsSchema.Parse(lines[0])
+		if err != nil {
+			return err
+		}
+		if ok {
+			g.version = env.Version
+			data = nil
+			if len(lines) == 2 {
+				data = lines[1]
+			}
+		}
+	}
+	data, err = gymsSchema.Upgrade(g.version, data)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		gym := Gym{}
 		err := json.Unmarshal(scanner.Bytes(), &gym)
@@ -87,7 +148,8 @@ func (g *GymDB) LoadGyms(r io.Reader) error {
 			return err
 		}
 		gym.Name = canonicalizeName(gym.Name)
-		gym.StreetAddr = strings.Join(strings.Split(gym.StreetAddr, ",")[:2], ",")
+		gym.StreetAddr = shortAddress(gym.StreetAddr)
+		gym.updateCell()
 		// searchable index w/ ids, names, and street addresses
 		key := gym.SearchKey()
 		g.Gyms[key] = &gym
@@ -97,7 +159,8 @@ func (g *GymDB) LoadGyms(r io.Reader) error {
 	return nil
 }
 
-func (g *GymDB) SaveGyms(w io.Writer) error {
+// SortedGyms returns all gyms ordered by name, then id
+func (g *GymDB) SortedGyms() []*Gym {
 	sortedGyms := make([]*Gym, 0, len(g.Gyms))
 	for _, gym := range g.Gyms {
 		sortedGyms = append(sortedGyms, gym)
@@ -108,7 +171,13 @@ func (g *GymDB) SaveGyms(w io.Writer) error {
 		}
 		return sortedGyms[i].Name < sortedGyms[j].Name
 	})
-	for _, gym := range sortedGyms {
+	return sortedGyms
+}
+
+func (g *GymDB) SaveGyms(w io.Writer) error {
+	w.Write(gymsSchema.Header())
+	w.Write([]byte("\n"))
+	for _, gym := range g.SortedGyms() {
 		data, err := json.Marshal(&gym)
 		if err != nil {
 			return err
@@ -120,6 +189,11 @@ func (g *GymDB) SaveGyms(w io.Writer) error {
 }
 
 func (g *GymDB) UpdateDiskDB() error {
+	if g.version != 0 && g.version < gymsSchema.Version {
+		if err := store.Backup(g.Filename, g.version); err != nil {
+			return err
+		}
+	}
 	tmpName := g.Filename + ".tmp"
 	f, err := os.Create(tmpName)
 	if err != nil {
@@ -136,6 +210,7 @@ func (g *GymDB) UpdateDiskDB() error {
 	if err != nil {
 		return err
 	}
+	g.version = gymsSchema.Version
 	return nil
 }
 
@@ -147,14 +222,24 @@ func (g *GymDB) UpdateSearchDB() {
 	g.Matcher = closestmatch.New(gymKeys, []int{2, 3, 4})
 }
 
+// GetGyms finds enabled gyms matching query
 func (g *GymDB) GetGyms(query string, threshold float32) ([]*Gym, []float32) {
+	return g.SearchGyms(query, threshold, false)
+}
+
+// SearchGyms returns the closest matches to query scoring within threshold of
+// the best match, optionally including disabled gyms, with normalized scores.
+func (g *GymDB) SearchGyms(query string, threshold float32, includeDisabled bool) ([]*Gym, []float32) {
 	matches := g.Matcher.ClosestN(canonicalizeQuery(query), 10)
 	var closest []*Gym
 	var normScores []float32
 	var normSum float32
 	log.Printf("query \"%s\" matches:", query)
 	for _, m := range matches {
-		if float32(m.Score) < float32(matches[0].Score)*threshold {
+		if !includeDisabled && !m.Data.(*Gym).Enabled {
+			continue
+		}
+		if len(closest) > 0 && float32(m.Score) < normScores[0]*threshold {
 			break
 		}
 		closest = append(closest, m.Data.(*Gym))
@@ -168,6 +253,15 @@ func (g *GymDB) GetGyms(query string, threshold float32) ([]*Gym, []float32) {
 	return closest, normScores
 }
 
+func (g *GymDB) GetGymById(id string) *Gym {
+	for _, gym := range g.Gyms {
+		if gym.Id == id {
+			return gym
+		}
+	}
+	return nil
+}
+
 func (g *GymDB) AddGym(lat, lon float64, name string) (*Gym, error) {
 	streetAddr, err := GetStreetAddress(lat, lon)
 	if err != nil {
@@ -177,10 +271,11 @@ func (g *GymDB) AddGym(lat, lon float64, name string) (*Gym, error) {
 		Id:         genId(),
 		Latitude:   lat,
 		Longitude:  lon,
-		StreetAddr: strings.Join(strings.Split(streetAddr, ",")[:2], ","),
+		StreetAddr: shortAddress(streetAddr),
 		Name:       canonicalizeName(name),
 		Enabled:    true,
 	}
+	gym.updateCell()
 	key := gym.SearchKey()
 	g.Gyms[key] = gym
 
@@ -193,7 +288,11 @@ func (g *GymDB) AddGym(lat, lon float64, name string) (*Gym, error) {
 	return gym, nil
 }
 
-func (g *GymDB) RemoveGym(gym *Gym) error {
+// RemoveGym deletes a gym from the DB, or if soft is set just disables it
+func (g *GymDB) RemoveGym(gym *Gym, soft bool) error {
+	if soft {
+		return g.SetEnabled(gym, false)
+	}
 	key := gym.SearchKey()
 	_, ok := g.Gyms[key]
 	if !ok {
@@ -208,6 +307,17 @@ func (g *GymDB) RemoveGym(gym *Gym) error {
 	return nil
 }
 
+// SetEnabled enables or disables a gym; disabled gyms are kept in the DB but
+// hidden from GetGyms and can't host raids.
+func (g *GymDB) SetEnabled(gym *Gym, enabled bool) error {
+	_, ok := g.Gyms[gym.SearchKey()]
+	if !ok {
+		return errors.New("can't find gym in DB")
+	}
+	gym.Enabled = enabled
+	return g.UpdateDiskDB()
+}
+
 func (g *GymDB) RenameGym(gym *Gym, name string) error {
 	oldKey := gym.SearchKey()
 	_, ok := g.Gyms[oldKey]
@@ -240,8 +350,8 @@ func (g *GymDB) MoveGym(gym *Gym, lat, lon float64) error {
 	delete(g.Gyms, oldKey)
 	gym.Latitude = lat
 	gym.Longitude = lon
-	gym.StreetAddr = strings.Join(strings.Split(
-		newStreetAddr, ",")[:2], ",")
+	gym.StreetAddr = shortAddress(newStreetAddr)
+	gym.updateCell()
 
 	key := gym.SearchKey()
 	g.Gyms[key] = gym
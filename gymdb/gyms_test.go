@@ -68,4 +68,32 @@ func TestGymDB_SaveGyms(t *testing.T) {
 	if roundTrip1 != roundTrip2 {
 		t.Fail()
 	}
-}
\ No newline at end of file
+}
+
+func TestGymDB_SetEnabled(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	denker := g.GetGymById("00000002")
+	err := g.RemoveGym(denker, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gs, _ := g.GetGyms("denker", 1.0); len(gs) != 0 {
+		t.Fatal("disabled gym found", gs)
+	}
+	if gs, _ := g.SearchGyms("denker", 1.0, true); len(gs) != 1 || gs[0] != denker {
+		t.Fatal("disabled gym not found", gs)
+	}
+	if len(g.Gyms) != 2 || NewGymDB(g.Filename).GetGymById("00000002").Enabled {
+		t.Fatal("soft delete not saved")
+	}
+
+	err = g.SetEnabled(denker, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gs, _ := g.GetGyms("denker", 1.0); len(gs) != 1 {
+		t.Fatal("enabled gym not found", gs)
+	}
+}
@@ -0,0 +1,523 @@
+package gymdb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"raidquaza/util"
+)
+
+// import/export formats; KML is export only
+const (
+	FormatCSV     = "csv"
+	FormatGeoJSON = "geojson"
+	FormatGPX     = "gpx"
+	FormatKML     = "kml"
+)
+
+// FormatFromFilename guesses an import/export format from a file extension
+func FormatFromFilename(fname string) (string, error) {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".geojson", ".json":
+		return FormatGeoJSON, nil
+	case ".gpx":
+		return FormatGPX, nil
+	case ".kml":
+		return FormatKML, nil
+	}
+	return "", fmt.Errorf("unknown gym file format %s", fname)
+}
+
+// CSVColumns names the header of the CSV column holding each gym field; empty
+// names are not read.
+type CSVColumns struct {
+	Id         string
+	Name       string
+	Latitude   string
+	Longitude  string
+	StreetAddr string
+	ImageUrl   string
+	Enabled    string
+	ExEligible string
+	Tags       string // space or comma separated
+}
+
+// DefaultCSVColumns matches the field names in gyms.txt, and the metadata keys
+// for EX eligibility and tags
+var DefaultCSVColumns = CSVColumns{
+	Id:         "gym_id",
+	Name:       "gym_name",
+	Latitude:   "latitude",
+	Longitude:  "longitude",
+	StreetAddr: "street_addr",
+	ImageUrl:   "url",
+	Enabled:    "enabled",
+	ExEligible: MetaExEligible,
+	Tags:       MetaTags,
+}
+
+// ParseCSVColumns overrides DefaultCSVColumns with a spec like
+// "name=Title,lat=Y,lon=X"; fields are id, name, lat, lon, addr, url, enabled,
+// ex and tags.
+func ParseCSVColumns(spec string) (CSVColumns, error) {
+	cols := DefaultCSVColumns
+	if spec == "" {
+		return cols, nil
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 {
+			return cols, fmt.Errorf("bad column spec %q; use field=header", kv)
+		}
+		header := strings.TrimSpace(split[1])
+		switch strings.TrimSpace(split[0]) {
+		case "id":
+			cols.Id = header
+		case "name":
+			cols.Name = header
+		case "lat":
+			cols.Latitude = header
+		case "lon":
+			cols.Longitude = header
+		case "addr":
+			cols.StreetAddr = header
+		case "url":
+			cols.ImageUrl = header
+		case "enabled":
+			cols.Enabled = header
+		case "ex":
+			cols.ExEligible = header
+		case "tags":
+			cols.Tags = header
+		default:
+			return cols, fmt.Errorf("unknown column field %q", split[0])
+		}
+	}
+	return cols, nil
+}
+
+// ReadGymFile reads gyms in any of the import formats
+func ReadGymFile(r io.Reader, format string, cols CSVColumns) ([]*Gym, error) {
+	switch format {
+	case FormatCSV:
+		return ReadCSV(r, cols)
+	case FormatGeoJSON:
+		return ReadGeoJSON(r)
+	case FormatGPX:
+		return ReadGPX(r)
+	}
+	return nil, fmt.Errorf("unknown gym file format %s", format)
+}
+
+// ReadCSV reads gyms from a CSV file with a header row
+func ReadCSV(r io.Reader, cols CSVColumns) ([]*Gym, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	colIndex := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i
+			}
+		}
+		return -1
+	}
+	idCol, nameCol := colIndex(cols.Id), colIndex(cols.Name)
+	latCol, lonCol := colIndex(cols.Latitude), colIndex(cols.Longitude)
+	addrCol, urlCol := colIndex(cols.StreetAddr), colIndex(cols.ImageUrl)
+	enabledCol := colIndex(cols.Enabled)
+	exCol, tagsCol := colIndex(cols.ExEligible), colIndex(cols.Tags)
+	if nameCol < 0 || latCol < 0 || lonCol < 0 {
+		return nil, errors.New("CSV needs name, latitude and longitude columns")
+	}
+
+	var gyms []*Gym
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return gyms, err
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		lat, err := strconv.ParseFloat(field(latCol), 64)
+		if err != nil {
+			return gyms, fmt.Errorf("line %d: %s", line, err)
+		}
+		lon, err := strconv.ParseFloat(field(lonCol), 64)
+		if err != nil {
+			return gyms, fmt.Errorf("line %d: %s", line, err)
+		}
+		gym := &Gym{
+			Id:         field(idCol),
+			Name:       field(nameCol),
+			Latitude:   lat,
+			Longitude:  lon,
+			StreetAddr: field(addrCol),
+			ImageUrl:   field(urlCol),
+			Enabled:    true,
+			Tags:       parseTags(field(tagsCol)),
+		}
+		if enabled := field(enabledCol); enabled != "" {
+			gym.Enabled, err = strconv.ParseBool(enabled)
+			if err != nil {
+				return gyms, fmt.Errorf("line %d: %s", line, err)
+			}
+		}
+		gym.ExEligible, err = parseYesNo(field(exCol))
+		if err != nil {
+			return gyms, fmt.Errorf("line %d: %s", line, err)
+		}
+		gyms = append(gyms, gym)
+	}
+	return gyms, nil
+}
+
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"` // shape depends on type
+	} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// ReadGeoJSON reads Point features from a GeoJSON FeatureCollection (or a
+// single Feature); other geometry types are skipped.
+func ReadGeoJSON(r io.Reader) ([]*Gym, error) {
+	var data struct {
+		geoJSONFeature
+		Features []geoJSONFeature `json:"features"`
+	}
+	err := json.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	features := data.Features
+	if data.Type == "Feature" {
+		features = []geoJSONFeature{data.geoJSONFeature}
+	}
+
+	prop := func(f *geoJSONFeature, keys ...string) string {
+		for _, k := range keys {
+			if v, ok := f.Properties[k]; ok && v != nil {
+				return strings.TrimSpace(fmt.Sprint(v))
+			}
+		}
+		return ""
+	}
+	var gyms []*Gym
+	for i := range features {
+		f := &features[i]
+		if f.Geometry == nil || f.Geometry.Type != "Point" {
+			continue
+		}
+		var coords []float64
+		err = json.Unmarshal(f.Geometry.Coordinates, &coords)
+		if err != nil || len(coords) < 2 {
+			return gyms, fmt.Errorf("feature %d has bad coordinates", i)
+		}
+		gym := &Gym{
+			Id:         prop(f, "gym_id", "id"),
+			Name:       prop(f, "gym_name", "name", "title"),
+			Longitude:  coords[0],
+			Latitude:   coords[1],
+			StreetAddr: prop(f, "street_addr", "address"),
+			ImageUrl:   prop(f, "url", "image"),
+			Enabled:    true,
+		}
+		if enabled, ok := f.Properties["enabled"].(bool); ok {
+			gym.Enabled = enabled
+		}
+		if ex, ok := f.Properties[MetaExEligible].(bool); ok {
+			gym.ExEligible = ex
+		}
+		if tags, ok := f.Properties[MetaTags].([]interface{}); ok {
+			for _, tag := range tags {
+				gym.Tags = appendUnique(gym.Tags, strings.ToLower(fmt.Sprint(tag)))
+			}
+		}
+		if gym.Name == "" {
+			return gyms, fmt.Errorf("feature %d has no name", i)
+		}
+		gyms = append(gyms, gym)
+	}
+	return gyms, nil
+}
+
+type gpxLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type gpxWaypoint struct {
+	Latitude   float64  `xml:"lat,attr"`
+	Longitude  float64  `xml:"lon,attr"`
+	Name       string   `xml:"name"`
+	Desc       string   `xml:"desc,omitempty"`
+	Link       *gpxLink `xml:"link"`
+	GymId      string   `xml:"extensions>gym_id,omitempty"`
+	Enabled    string   `xml:"extensions>enabled,omitempty"`
+	ExEligible string   `xml:"extensions>ex,omitempty"`
+	Tags       string   `xml:"extensions>tags,omitempty"`
+}
+
+// ReadGPX reads gyms from GPX waypoints; the description is taken as the
+// street address and the link as the image url.
+func ReadGPX(r io.Reader) ([]*Gym, error) {
+	var data struct {
+		Waypoints []gpxWaypoint `xml:"wpt"`
+	}
+	err := xml.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	var gyms []*Gym
+	for i, wpt := range data.Waypoints {
+		if wpt.Name == "" {
+			return gyms, fmt.Errorf("waypoint %d has no name", i)
+		}
+		gym := &Gym{
+			Id:         strings.TrimSpace(wpt.GymId),
+			Name:       strings.TrimSpace(wpt.Name),
+			Latitude:   wpt.Latitude,
+			Longitude:  wpt.Longitude,
+			StreetAddr: strings.TrimSpace(wpt.Desc),
+			Enabled:    true,
+			Tags:       parseTags(wpt.Tags),
+		}
+		if wpt.Link != nil {
+			gym.ImageUrl = wpt.Link.Href
+		}
+		if wpt.Enabled != "" {
+			gym.Enabled, err = strconv.ParseBool(strings.TrimSpace(wpt.Enabled))
+			if err != nil {
+				return gyms, fmt.Errorf("waypoint %d: %s", i, err)
+			}
+		}
+		gym.ExEligible, err = parseYesNo(strings.TrimSpace(wpt.ExEligible))
+		if err != nil {
+			return gyms, fmt.Errorf("waypoint %d: %s", i, err)
+		}
+		gyms = append(gyms, gym)
+	}
+	return gyms, nil
+}
+
+// ImportOptions controls how imported gyms are matched against the DB
+type ImportOptions struct {
+	MoveDistance        float64 // meters a gym with a known id must move to count as moved
+	DuplicateDistance   float64 // meters within which a new gym may duplicate an existing one
+	DuplicateSimilarity float64 // minimum NameSimilarity for a possible duplicate
+}
+
+var DefaultImportOptions = ImportOptions{
+	MoveDistance:        1,
+	DuplicateDistance:   50,
+	DuplicateSimilarity: 0.5,
+}
+
+// GymChange pairs an imported gym with the existing gym it matched
+type GymChange struct {
+	Existing   *Gym
+	Imported   *Gym
+	Distance   float64 // meters
+	Similarity float64 // NameSimilarity of the two names
+}
+
+// ImportDiff is the dry-run result of an import; a gym both moved and renamed
+// appears in both lists.
+type ImportDiff struct {
+	New        []*Gym
+	Moved      []GymChange
+	Renamed    []GymChange
+	Duplicates []GymChange // possible duplicates; not applied as new gyms by default
+	Unchanged  int
+}
+
+// DiffImport matches imported gyms against the DB without changing anything.
+// Gyms are matched by id when the file has one; otherwise an existing gym at
+// the same spot with the same name is unchanged, and a nearby gym with a
+// similar name is a possible duplicate.
+func (g *GymDB) DiffImport(gyms []*Gym, opts ImportOptions) *ImportDiff {
+	diff := &ImportDiff{}
+	for _, im := range gyms {
+		im.Name = canonicalizeName(im.Name)
+		im.StreetAddr = shortAddress(im.StreetAddr)
+
+		if existing := g.GetGymById(im.Id); im.Id != "" && existing != nil {
+			change := GymChange{
+				Existing: existing,
+				Imported: im,
+				Distance: util.Distance(existing.Latitude, existing.Longitude,
+					im.Latitude, im.Longitude),
+				Similarity: NameSimilarity(existing.Name, im.Name),
+			}
+			changed := false
+			if change.Distance >= opts.MoveDistance {
+				diff.Moved = append(diff.Moved, change)
+				changed = true
+			}
+			if existing.Name != im.Name {
+				diff.Renamed = append(diff.Renamed, change)
+				changed = true
+			}
+			if !changed {
+				diff.Unchanged++
+			}
+			continue
+		}
+
+		var best *GymChange
+		for _, existing := range g.Gyms {
+			dist := util.Distance(existing.Latitude, existing.Longitude, im.Latitude, im.Longitude)
+			if dist > opts.DuplicateDistance {
+				continue
+			}
+			sim := NameSimilarity(existing.Name, im.Name)
+			if sim < opts.DuplicateSimilarity {
+				continue
+			}
+			if best == nil || sim > best.Similarity ||
+				(sim == best.Similarity && dist < best.Distance) {
+				best = &GymChange{Existing: existing, Imported: im, Distance: dist, Similarity: sim}
+			}
+		}
+		if best == nil {
+			diff.New = append(diff.New, im)
+		} else if best.Existing.Name == im.Name && best.Distance < opts.MoveDistance {
+			diff.Unchanged++
+		} else {
+			diff.Duplicates = append(diff.Duplicates, *best)
+		}
+	}
+	return diff
+}
+
+// Empty reports whether applying the diff would change nothing
+func (d *ImportDiff) Empty() bool {
+	return len(d.New) == 0 && len(d.Moved) == 0 && len(d.Renamed) == 0
+}
+
+// WriteTo writes a human-readable summary of the diff
+func (d *ImportDiff) WriteTo(w io.Writer) (int64, error) {
+	var lines []string
+	for _, gym := range d.New {
+		lines = append(lines, fmt.Sprintf("+ new       %s (%0.7f,%0.7f)",
+			gym.Name, gym.Latitude, gym.Longitude))
+	}
+	for _, c := range d.Moved {
+		lines = append(lines, fmt.Sprintf("~ moved     [gym %s] %s %0.1fm to (%0.7f,%0.7f)",
+			c.Existing.Id, c.Existing.Name, c.Distance, c.Imported.Latitude, c.Imported.Longitude))
+	}
+	for _, c := range d.Renamed {
+		lines = append(lines, fmt.Sprintf("~ renamed   [gym %s] %s -> %s",
+			c.Existing.Id, c.Existing.Name, c.Imported.Name))
+	}
+	for _, c := range d.Duplicates {
+		lines = append(lines, fmt.Sprintf("? duplicate %s looks like [gym %s] %s (%0.1fm, %0.0f%% similar)",
+			c.Imported.Name, c.Existing.Id, c.Existing.Name, c.Distance, c.Similarity*100))
+	}
+	lines = append(lines, fmt.Sprintf("%d new, %d moved, %d renamed, %d possible duplicates, %d unchanged",
+		len(d.New), len(d.Moved), len(d.Renamed), len(d.Duplicates), d.Unchanged))
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return int64(n), err
+}
+
+// ApplyImport applies a diff from DiffImport, adding possible duplicates as
+// new gyms only if includeDuplicates is set. The new DB is written to disk
+// before anything changes in memory, so on error the DB is left untouched.
+// Existing gyms are updated in place, so raids pointing at them follow along.
+func (g *GymDB) ApplyImport(d *ImportDiff, includeDuplicates bool) error {
+	// stage copies of every changed gym
+	staged := make(map[*Gym]Gym)
+	stage := func(gym *Gym) Gym {
+		if v, ok := staged[gym]; ok {
+			return v
+		}
+		return *gym
+	}
+	for _, c := range d.Moved {
+		v := stage(c.Existing)
+		v.Latitude = c.Imported.Latitude
+		v.Longitude = c.Imported.Longitude
+		if c.Imported.StreetAddr != "" {
+			v.StreetAddr = c.Imported.StreetAddr
+		}
+		v.updateCell()
+		staged[c.Existing] = v
+	}
+	for _, c := range d.Renamed {
+		v := stage(c.Existing)
+		v.Name = c.Imported.Name
+		staged[c.Existing] = v
+	}
+
+	added := append([]*Gym(nil), d.New...)
+	if includeDuplicates {
+		for _, c := range d.Duplicates {
+			added = append(added, c.Imported)
+		}
+	}
+	ids := make(map[string]bool, len(g.Gyms)+len(added))
+	for _, gym := range g.Gyms {
+		ids[gym.Id] = true
+	}
+	for _, gym := range added {
+		for gym.Id == "" || ids[gym.Id] {
+			gym.Id = genId()
+		}
+		ids[gym.Id] = true
+		gym.updateCell()
+	}
+
+	next := &GymDB{Gyms: make(map[string]*Gym), Filename: g.Filename, version: g.version}
+	for _, gym := range g.Gyms {
+		if v, ok := staged[gym]; ok {
+			gym = &v
+		}
+		next.Gyms[gym.SearchKey()] = gym
+	}
+	for _, gym := range added {
+		next.Gyms[gym.SearchKey()] = gym
+	}
+	err := next.UpdateDiskDB()
+	if err != nil {
+		return err
+	}
+
+	// commit
+	for gym, v := range staged {
+		*gym = v
+	}
+	gyms := make(map[string]*Gym, len(next.Gyms))
+	for _, gym := range g.Gyms {
+		gyms[gym.SearchKey()] = gym
+	}
+	for _, gym := range added {
+		gyms[gym.SearchKey()] = gym
+	}
+	g.Gyms = gyms
+	g.UpdateSearchDB()
+	return nil
+}
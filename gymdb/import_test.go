@@ -0,0 +1,104 @@
+package gymdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGyms = `{"gym_id":"00000001","gym_name":"Valley Trails Park","latitude":37.6935,"longitude":-121.9023,"street_addr":"Valley Trails Dr, Pleasanton","enabled":true}
+{"gym_id":"00000002","gym_name":"Denker Park","latitude":37.6601,"longitude":-121.8862,"street_addr":"Denker Dr, Pleasanton","enabled":true}
+`
+
+func newTestGymDB(t *testing.T) (*GymDB, func()) {
+	dir, err := ioutil.TempDir("", "gymdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &GymDB{Gyms: make(map[string]*Gym), Filename: filepath.Join(dir, "gyms.txt")}
+	err = g.LoadGyms(strings.NewReader(testGyms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, func() { os.RemoveAll(dir) }
+}
+
+func TestReadGymFile(t *testing.T) {
+	csvData := "Title,Y,X\nValley Trails Park,37.6935,-121.9023\n\"Fountain, Big\",37.7,-121.9\n"
+	cols, err := ParseCSVColumns("name=Title,lat=Y,lon=X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gyms, err := ReadGymFile(strings.NewReader(csvData), FormatCSV, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gyms) != 2 || gyms[1].Name != "Fountain, Big" || gyms[1].Longitude != -121.9 {
+		t.Fatal(gyms)
+	}
+
+	geoData := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[-121.9023,37.6935]},"properties":{"name":"Valley Trails Park"}},
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]},"properties":{"name":"trail"}}]}`
+	gyms, err = ReadGymFile(strings.NewReader(geoData), FormatGeoJSON, DefaultCSVColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gyms) != 1 || gyms[0].Latitude != 37.6935 || !gyms[0].Enabled {
+		t.Fatal(gyms)
+	}
+
+	gpxData := `<?xml version="1.0"?><gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+		<wpt lat="37.6601" lon="-121.8862"><name>Denker Park</name><desc>Denker Dr, Pleasanton</desc>
+		<extensions><gym_id>00000002</gym_id><enabled>false</enabled></extensions></wpt></gpx>`
+	gyms, err = ReadGymFile(strings.NewReader(gpxData), FormatGPX, DefaultCSVColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gyms) != 1 || gyms[0].Id != "00000002" || gyms[0].Enabled {
+		t.Fatal(gyms)
+	}
+}
+
+func TestGymDB_DiffImport(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	imported := []*Gym{
+		{Id: "00000001", Name: "Valley Trails Park", Latitude: 37.6945, Longitude: -121.9023},
+		{Id: "00000002", Name: "Denker Park Sign", Latitude: 37.6601, Longitude: -121.8862},
+		{Name: "Denker Park Gazebo", Latitude: 37.6602, Longitude: -121.8862},
+		{Name: "Valley Trails Park", Latitude: 37.6935, Longitude: -121.9023},
+		{Name: "Brand New Mural", Latitude: 37.70, Longitude: -121.91},
+	}
+	diff := g.DiffImport(imported, DefaultImportOptions)
+	var out strings.Builder
+	diff.WriteTo(&out)
+	t.Log(out.String())
+	if len(diff.New) != 1 || len(diff.Moved) != 1 || len(diff.Renamed) != 1 ||
+		len(diff.Duplicates) != 1 || diff.Unchanged != 1 {
+		t.Fatal("unexpected diff")
+	}
+
+	valleyTrails := g.GetGymById("00000001")
+	err := g.ApplyImport(diff, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Gyms) != 3 {
+		t.Fatal(len(g.Gyms))
+	}
+	if valleyTrails.Latitude != 37.6945 {
+		t.Fatal("moved gym not updated in place")
+	}
+	if gs, _ := g.GetGyms("denker park sign", 1.0); len(gs) != 1 || gs[0].Id != "00000002" {
+		t.Fatal("renamed gym not found")
+	}
+
+	reloaded := NewGymDB(g.Filename)
+	if len(reloaded.Gyms) != 3 {
+		t.Fatal("import not saved")
+	}
+}
@@ -0,0 +1,56 @@
+package gymdb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// letter/digit bigrams of a gym name, ignoring case, punctuation and spacing
+func nameBigrams(name string) map[string]int {
+	var runes []rune
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			runes = append(runes, r)
+		}
+	}
+	bigrams := make(map[string]int)
+	for i := 0; i+1 < len(runes); i++ {
+		bigrams[string(runes[i:i+2])]++
+	}
+	return bigrams
+}
+
+// NameSimilarity returns the Dice coefficient of the two names' bigrams: 1.0
+// for names that differ only in case/punctuation, 0.0 for nothing in common.
+func NameSimilarity(a, b string) float64 {
+	ba, bb := nameBigrams(a), nameBigrams(b)
+	total := 0
+	for _, n := range ba {
+		total += n
+	}
+	for _, n := range bb {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	common := 0
+	for k, na := range ba {
+		nb := bb[k]
+		if nb < na {
+			common += nb
+		} else {
+			common += na
+		}
+	}
+	return 2 * float64(common) / float64(total)
+}
+
+// trim a geocoded address down to street and city
+func shortAddress(addr string) string {
+	parts := strings.Split(addr, ",")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.TrimSpace(strings.Join(parts, ","))
+}
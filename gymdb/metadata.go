@@ -0,0 +1,202 @@
+package gymdb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metadata keys with their own Gym fields; anything else is an Attribute
+const (
+	MetaExEligible = "ex"
+	MetaTags       = "tags"
+	MetaS2Cell     = "cell"
+	MetaNotes      = "notes"
+)
+
+// parse yes/no style booleans from chat
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "y", "yes", "on":
+		return true, nil
+	case "n", "no", "off", "":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func parseTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		tags = appendUnique(tags, tag)
+	}
+	return tags
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, x := range list {
+		if x == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func (g *Gym) HasTag(tag string) bool {
+	for _, t := range g.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata returns the gym's metadata as key/value pairs in display order,
+// omitting unset keys
+func (g *Gym) Metadata() [][2]string {
+	var meta [][2]string
+	if g.ExEligible {
+		meta = append(meta, [2]string{MetaExEligible, "yes"})
+	}
+	if len(g.Tags) > 0 {
+		meta = append(meta, [2]string{MetaTags, strings.Join(g.Tags, ", ")})
+	}
+	if g.S2Cell != "" {
+		meta = append(meta, [2]string{MetaS2Cell, g.S2Cell})
+	}
+	if g.Notes != "" {
+		meta = append(meta, [2]string{MetaNotes, g.Notes})
+	}
+	var keys []string
+	for k := range g.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		meta = append(meta, [2]string{k, g.Attributes[k]})
+	}
+	return meta
+}
+
+// SetMetadata sets one metadata key on a gym; an empty value clears it. Tags
+// are given as a comma or space separated list replacing the current tags.
+// The S2 cell can't be set; it follows the gym's location.
+func (g *GymDB) SetMetadata(gym *Gym, key, value string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+	if key == "" {
+		return errors.New("missing metadata key")
+	}
+	oldKey := gym.SearchKey()
+	if _, ok := g.Gyms[oldKey]; !ok {
+		return errors.New("can't find gym in DB")
+	}
+
+	updated := *gym
+	switch key {
+	case MetaExEligible:
+		ex, err := parseYesNo(value)
+		if err != nil {
+			return fmt.Errorf("%s should be yes or no", key)
+		}
+		updated.ExEligible = ex
+	case MetaTags:
+		updated.Tags = parseTags(value)
+	case MetaS2Cell:
+		return errors.New("the S2 cell is computed from the gym's location")
+	case MetaNotes:
+		updated.Notes = value
+	default:
+		attrs := make(map[string]string, len(gym.Attributes)+1)
+		for k, v := range gym.Attributes {
+			attrs[k] = v
+		}
+		if value == "" {
+			delete(attrs, key)
+		} else {
+			attrs[key] = value
+		}
+		if len(attrs) == 0 {
+			attrs = nil
+		}
+		updated.Attributes = attrs
+	}
+
+	delete(g.Gyms, oldKey)
+	*gym = updated
+	g.Gyms[gym.SearchKey()] = gym
+	g.UpdateSearchDB()
+	return g.UpdateDiskDB()
+}
+
+// GymFilter narrows gym searches by metadata
+type GymFilter struct {
+	ExEligible *bool
+	Tags       []string          // gym must have all of these
+	Attributes map[string]string // gym must have all of these values
+}
+
+// ParseGymFilter pulls filter terms out of a search query, returning the rest
+// of the query. Terms are ex:yes|no, tag:<tag> and <attribute>=<value>.
+func ParseGymFilter(query string) (string, GymFilter) {
+	var filter GymFilter
+	var rest []string
+	for _, term := range strings.Fields(query) {
+		lower := strings.ToLower(term)
+		if strings.HasPrefix(lower, MetaExEligible+":") {
+			if ex, err := parseYesNo(lower[len(MetaExEligible)+1:]); err == nil {
+				filter.ExEligible = &ex
+				continue
+			}
+		} else if strings.HasPrefix(lower, "tag:") && len(lower) > len("tag:") {
+			filter.Tags = append(filter.Tags, lower[len("tag:"):])
+			continue
+		} else if kv := strings.SplitN(term, "=", 2); len(kv) == 2 && kv[0] != "" {
+			if filter.Attributes == nil {
+				filter.Attributes = make(map[string]string)
+			}
+			filter.Attributes[strings.ToLower(kv[0])] = kv[1]
+			continue
+		}
+		rest = append(rest, term)
+	}
+	return strings.Join(rest, " "), filter
+}
+
+// Empty reports whether the filter matches every gym
+func (f GymFilter) Empty() bool {
+	return f.ExEligible == nil && len(f.Tags) == 0 && len(f.Attributes) == 0
+}
+
+func (f GymFilter) Match(gym *Gym) bool {
+	if f.ExEligible != nil && gym.ExEligible != *f.ExEligible {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !gym.HasTag(tag) {
+			return false
+		}
+	}
+	for k, v := range f.Attributes {
+		if !strings.EqualFold(gym.Attributes[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterGyms returns the gyms matching f, sorted like SortedGyms, optionally
+// including disabled gyms
+func (g *GymDB) FilterGyms(f GymFilter, includeDisabled bool) []*Gym {
+	var gyms []*Gym
+	for _, gym := range g.SortedGyms() {
+		if (includeDisabled || gym.Enabled) && f.Match(gym) {
+			gyms = append(gyms, gym)
+		}
+	}
+	return gyms
+}
@@ -0,0 +1,48 @@
+package gymdb
+
+import "testing"
+
+func TestGymDB_SetMetadata(t *testing.T) {
+	g, cleanup := newTestGymDB(t)
+	defer cleanup()
+
+	denker := g.GetGymById("00000002")
+	for _, kv := range [][2]string{
+		{"ex", "yes"},
+		{"tags", "Park, sponsored"},
+		{"notes", "by the gazebo"},
+		{"color", "blue"},
+	} {
+		err := g.SetMetadata(denker, kv[0], kv[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Log(denker.Metadata())
+	if !denker.ExEligible || !denker.HasTag("park") || denker.Attributes["color"] != "blue" {
+		t.Fatal(denker)
+	}
+	if err := g.SetMetadata(denker, "ex", "maybe"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	reloaded := NewGymDB(g.Filename).GetGymById("00000002")
+	if len(reloaded.Metadata()) != 5 || reloaded.Notes != "by the gazebo" {
+		t.Fatal("metadata not saved", reloaded.Metadata())
+	}
+
+	query, filter := ParseGymFilter("park ex:yes tag:sponsored color=Blue")
+	if query != "park" || filter.Empty() {
+		t.Fatal(query, filter)
+	}
+	if gs := g.FilterGyms(filter, false); len(gs) != 1 || gs[0] != denker {
+		t.Fatal(gs)
+	}
+	err := g.SetMetadata(denker, "color", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if denker.Attributes != nil || len(g.FilterGyms(filter, false)) != 0 {
+		t.Fatal(denker.Attributes)
+	}
+}
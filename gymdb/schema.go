@@ -0,0 +1,13 @@
+package gymdb
+
+import "raidquaza/store"
+
+// gyms.txt: a header line with the schema version, then one gym per line
+var gymsSchema = store.NewSchema("gyms", 2)
+
+func init() {
+	// v1: gyms with no header line, from before the file was versioned
+	gymsSchema.Register(1, func(data []byte) ([]byte, error) {
+		return data, nil
+	})
+}
@@ -0,0 +1,67 @@
+package gymdb
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"raidquaza/store"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// each historical gyms.txt version loads and saves as the current version
+func TestGymsSchema(t *testing.T) {
+	golden := fmt.Sprintf("testdata/gyms_v%d.txt", gymsSchema.Version)
+	for v := 1; v <= gymsSchema.Version; v++ {
+		orig, err := ioutil.ReadFile(fmt.Sprintf("testdata/gyms_v%d.txt", v))
+		if err != nil {
+			if *update && v == gymsSchema.Version {
+				continue
+			}
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir("", "gymdb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "gyms.txt")
+		ioutil.WriteFile(path, orig, 0644)
+
+		g := NewGymDB(path)
+		if len(g.Gyms) != 2 {
+			t.Fatalf("v%d: loaded %d gyms", v, len(g.Gyms))
+		}
+		if err := g.UpdateDiskDB(); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadFile(path)
+		if *update && v == 1 {
+			ioutil.WriteFile(golden, got, 0644)
+		}
+		want, _ := ioutil.ReadFile(golden)
+		if string(got) != string(want) {
+			t.Errorf("v%d saved as\n%s\nwant\n%s", v, got, want)
+		}
+
+		bak, err := ioutil.ReadFile(store.BackupPath(path, v))
+		if v < gymsSchema.Version && string(bak) != string(orig) {
+			t.Errorf("v%d: no backup of the original: %v", v, err)
+		} else if v == gymsSchema.Version && err == nil {
+			t.Errorf("v%d: backed up the current version", v)
+		}
+	}
+}
+
+func TestGymsSchema_Newer(t *testing.T) {
+	g := &GymDB{Gyms: make(map[string]*Gym)}
+	header := fmt.Sprintf(`{"schema":"gyms","version":%d}`, gymsSchema.Version+1)
+	if err := g.LoadGyms(bytes.NewBufferString(header + "\n")); err == nil {
+		t.Fatal("loaded a newer version")
+	}
+}
@@ -14,13 +14,17 @@ import (
 const snapshotPath = "rqdata.json"
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	dg, err := discordgo.New("Bot " + util.LoadAuthToken("authtoken.txt"))
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	botState := raid.NewBotState(dg, snapshotPath, "gymdb/gyms.txt")
+	botState := raid.NewBotState(dg, snapshotPath, defaultGymPath)
 
 	// Open a websocket connection to Discord and begin listening.
 	err = dg.Open()
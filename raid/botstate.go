@@ -2,6 +2,7 @@ package raid
 
 import (
 	"raidquaza/gymdb"
+	"raidquaza/store"
 	"github.com/bwmarrin/discordgo"
 	"sync"
 	"os"
@@ -10,11 +11,13 @@ import (
 	"time"
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"io/ioutil"
 )
 
 type ActiveMessage interface {
-	OnReactionAdd(bs *BotState, s *discordgo.Session, m *discordgo.MessageReactionAdd)
-	OnReactionRemove(bs *BotState, s *discordgo.Session, m *discordgo.MessageReactionRemove)
+	OnReactionAdd(bs *BotState, s Session, m *discordgo.MessageReactionAdd)
+	OnReactionRemove(bs *BotState, s Session, m *discordgo.MessageReactionRemove)
 	OnMessageEdit(bs *BotState, s *discordgo.Session, m *discordgo.MessageUpdate)
 	OnMessageDelete(bs *BotState, s *discordgo.Session, m *discordgo.MessageDelete)
 }
@@ -28,17 +31,45 @@ type BotState struct {
 
 	Raids map[string]*Raid `json:"raids"` // message id -> raid
 
-	channelCallbacks map[string]func(*discordgo.Session, *discordgo.MessageCreate)
-	activeMessages   map[string]ActiveMessage
+	Users         map[string]*UserSettings `json:"users,omitempty"`          // userid -> settings
+	ReminderLeads map[string][]int         `json:"reminder_leads,omitempty"` // guild id -> minutes; see reminderLeads
+	RemoteCaps    map[string]int           `json:"remote_caps,omitempty"`    // guild id -> remote accounts a group takes; see limits
+	GroupCaps     map[string]int           `json:"group_caps,omitempty"`     // guild id -> accounts a group takes; DefaultGroupCap for others
 
-	dirty bool
+	Prompts  map[string][]*Prompt      `json:"prompts,omitempty"`  // userid -> prompts awaiting an answer
+	Outcomes map[string]*OutcomeRecord `json:"outcomes,omitempty"` // message id -> group outcomes being reported
 
-	// giant global lock
-	mut          sync.Mutex
-	expireTicker *time.Ticker
+	activeMessages map[string]ActiveMessage
+
+	dirty int32 // set atomically
+
+	// mut guards the maps and settings above; each raid has its own lock
+	// for its state. Locks are taken in the order raid, bs.mut, scheduler,
+	// and never held across discord calls.
+	mut     sync.Mutex
+	saveMut sync.Mutex
+
+	session       Session // for calls needing their results; everything else goes through outbox
+	clock         Clock
+	sched         *Scheduler
+	outbox        *Outbox
+	journal       *store.Journal
+	history       *History
+	snapshotPath  string
+	loadedVersion int // schema version of the snapshot loaded, guarded by saveMut
 }
 
-var globalEmojiMap map[string]string
+var (
+	globalEmojiMap map[string]string
+	emojiMut       sync.RWMutex // guards globalEmojiMap
+)
+
+func (bs *BotState) activeMessage(messageID string) (ActiveMessage, bool) {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	msg, ok := bs.activeMessages[messageID]
+	return msg, ok
+}
 
 func (bs *BotState) RemoveActiveMessage(messageID string) {
 	bs.mut.Lock()
@@ -47,6 +78,8 @@ func (bs *BotState) RemoveActiveMessage(messageID string) {
 }
 
 func (bs *BotState) guildEmoji(emojiName string) (string, bool) {
+	emojiMut.RLock()
+	defer emojiMut.RUnlock()
 	emojiId, ok := bs.emojiMap[emojiName]
 	if !ok {
 		return "", false
@@ -54,35 +87,197 @@ func (bs *BotState) guildEmoji(emojiName string) (string, bool) {
 	return fmt.Sprintf(":%s:%s", emojiName, emojiId), true
 }
 
+func (bs *BotState) markDirty() {
+	atomic.StoreInt32(&bs.dirty, 1)
+}
+
+func (bs *BotState) saveIfDirty() {
+	if atomic.CompareAndSwapInt32(&bs.dirty, 1, 0) {
+		if err := bs.Save(bs.snapshotPath); err != nil {
+			log.Print(err)
+			bs.markDirty()
+		}
+	}
+}
+
+// look up a raid by its message id
+func (bs *BotState) raid(messageID string) (*Raid, bool) {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	raid, ok := bs.Raids[messageID]
+	return raid, ok
+}
+
+func (bs *BotState) raidList() []*Raid {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	raids := make([]*Raid, 0, len(bs.Raids))
+	for _, raid := range bs.Raids {
+		raids = append(raids, raid)
+	}
+	return raids
+}
+
+// the raid a command refers to, by the id of its post or the name of its
+// gym
+func (bs *BotState) findRaid(ref string) (*Raid, error) {
+	ref = strings.TrimSpace(ref)
+	if raid, ok := bs.raid(ref); ok {
+		return raid, nil
+	}
+	var found []*Raid
+	for _, raid := range bs.raidList() {
+		raid.mut.Lock()
+		name := raid.Gym.Name
+		raid.mut.Unlock()
+		if ref != "" && strings.Contains(strings.ToLower(name), strings.ToLower(ref)) {
+			found = append(found, raid)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no raid at %q", ref)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%d raids match %q; use the id of the raid's message", len(found), ref)
+}
+
+// call f with a raid locked, then queue whatever it sent to discord; returns
+// false if there's no such raid
+func (bs *BotState) withRaid(messageID string, f func(raid *Raid, out *deferred)) bool {
+	raid, ok := bs.raid(messageID)
+	if !ok {
+		return false
+	}
+	out := &deferred{}
+	raid.mut.Lock()
+	f(raid, out)
+	raid.mut.Unlock()
+	out.flush(bs.outbox)
+	return true
+}
+
+// edit a raid's post once out is flushed, showing the raid as it is then
+// rather than now: the post is rendered under the raid's lock, so however
+// the flushes of racing updates interleave, the last edit queued shows the
+// latest state. out must be flushed without holding the lock.
+func (bs *BotState) updatePost(out *deferred, raid *Raid) {
+	out.do(func(s Session) {
+		raid.mut.Lock()
+		defer raid.mut.Unlock()
+		raid.SendUpdate(s, bs.limits(raid.GuildID))
+	})
+}
+
+// the snapshot, with each raid marshaled under its own lock
+func (bs *BotState) marshal() ([]byte, error) {
+	bs.mut.Lock()
+	raids := make(map[string]*Raid, len(bs.Raids))
+	for id, raid := range bs.Raids {
+		raids[id] = raid
+	}
+	snap := struct {
+		Raids         map[string]json.RawMessage `json:"raids"`
+		Users         map[string]*UserSettings   `json:"users,omitempty"`
+		ReminderLeads map[string][]int           `json:"reminder_leads,omitempty"`
+		RemoteCaps    map[string]int             `json:"remote_caps,omitempty"`
+		GroupCaps     map[string]int             `json:"group_caps,omitempty"`
+		Prompts       map[string][]Prompt        `json:"prompts,omitempty"`
+		Outcomes      map[string]json.RawMessage `json:"outcomes,omitempty"`
+	}{make(map[string]json.RawMessage), make(map[string]*UserSettings), bs.ReminderLeads,
+		bs.RemoteCaps, bs.GroupCaps, make(map[string][]Prompt), make(map[string]json.RawMessage)}
+	for id, u := range bs.Users {
+		snap.Users[id] = u
+	}
+	for id, prompts := range bs.Prompts {
+		for _, p := range prompts {
+			snap.Prompts[id] = append(snap.Prompts[id], *p)
+		}
+	}
+	for id, o := range bs.Outcomes {
+		m, err := json.Marshal(o)
+		if err != nil {
+			bs.mut.Unlock()
+			return nil, err
+		}
+		snap.Outcomes[id] = m
+	}
+	bs.mut.Unlock()
+
+	for id, raid := range raids {
+		raid.mut.Lock()
+		m, err := json.Marshal(raid)
+		raid.mut.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		snap.Raids[id] = m
+	}
+	return json.Marshal(snap)
+}
+
+// Save compacts the state: writes a snapshot of it to path, then drops the
+// journal records it covers
 func (bs *BotState) Save(path string) error {
-	tmpPath := path + "_tmp"
-	f, err := os.Create(tmpPath)
+	bs.saveMut.Lock()
+	defer bs.saveMut.Unlock()
+
+	// move the journal aside; everything in it is in the state we're about
+	// to snapshot. If a previous snapshot failed, it's still there, and the
+	// current journal will just be replayed on top of the snapshot.
+	oldPath := path + journalSuffix + ".old"
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) && bs.journal != nil {
+		if err := bs.journal.Rotate(oldPath); err != nil {
+			return err
+		}
+	}
+	m, err := bs.marshal()
+	if err == nil {
+		m, err = snapshotSchema.Wrap(m)
+	}
 	if err != nil {
 		return err
 	}
-	m, err := json.Marshal(bs)
-	if err != nil {
+	if bs.loadedVersion != 0 && bs.loadedVersion < snapshotSchema.Version {
+		if err := store.Backup(path, bs.loadedVersion); err != nil {
+			return err
+		}
+	}
+	if err := store.WriteFileAtomic(path, m); err != nil {
+		return err
+	}
+	bs.loadedVersion = snapshotSchema.Version
+	if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	f.Write(m)
-	f.Close()
-	os.Remove(path)
-	os.Rename(tmpPath, path)
 	log.Printf("Saved state snapshot to %s", path)
 	return nil
 }
 
+// Load reads the snapshot at path, upgrading it from older versions, and
+// replays the journal on top of it
 func (bs *BotState) Load(path string) error {
-	f, err := os.Open(path)
-	if err != nil {
+	m, err := ioutil.ReadFile(path)
+	if err == nil {
+		data, version, err := snapshotSchema.Unwrap(m)
+		if err == nil {
+			err = json.Unmarshal(data, bs)
+		}
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+		bs.loadedVersion = version
+	} else if !os.IsNotExist(err) {
 		return err
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
-	err = dec.Decode(bs)
-	if err != nil {
-		log.Print(err)
-		return err
+
+	for _, jpath := range []string{path + journalSuffix + ".old", path + journalSuffix} {
+		if err := store.ReadJournal(jpath, bs.applyRecord); err != nil {
+			log.Print(err)
+			return err
+		}
 	}
 
 	// fixup raid pointers not serialized
@@ -94,75 +289,227 @@ func (bs *BotState) Load(path string) error {
 	return nil
 }
 
-func (bs *BotState) ExpireOld(s *discordgo.Session, t time.Time) {
-	bs.mut.Lock()
-	defer bs.mut.Unlock()
+// how often to snapshot the state when it's changed
+const saveInterval = 10 * time.Second
 
-	for k, raid := range bs.Raids {
-		needUpdate := false
-		for _, rg := range raid.Groups {
-			if !rg.Expired && t.After(rg.StartTime) {
-				rg.Expire(s)
-				needUpdate = true
-				bs.dirty = true
+// (re)schedule every event still to come for a raid, e.g. after it's been
+// posted, edited or loaded; must hold raid.mut
+func (bs *BotState) scheduleRaid(raid *Raid) {
+	id := raid.MessageID
+	bs.sched.CancelRaid(id)
+	if !raid.Hatched {
+		bs.sched.Schedule(Event{When: raid.HatchTime(), Kind: EventHatch, RaidID: id, Group: -1})
+	}
+	bs.sched.Schedule(Event{When: raid.EndTime, Kind: EventRaidEnd, RaidID: id, Group: -1})
+	leads := bs.reminderLeads(raid.GuildID)
+	for _, rg := range raid.Groups {
+		if rg.Expired {
+			continue
+		}
+		n := rg.Number
+		bs.sched.Schedule(Event{When: rg.StartTime, Kind: EventGroupStart, RaidID: id, Group: n})
+		bs.sched.Schedule(Event{When: rg.StartTime.Add(-inviteLead), Kind: EventInvites, RaidID: id, Group: n})
+	lead:
+		for _, lead := range leads {
+			for _, r := range rg.Reminded {
+				if r == lead {
+					continue lead
+				}
 			}
+			bs.sched.Schedule(Event{When: rg.StartTime.Add(-time.Duration(lead) * time.Minute),
+				Kind: EventReminder, RaidID: id, Group: n, Lead: lead})
 		}
-
-		if !raid.Hatched && t.After(raid.EndTime.Add(-RaidDuration)) {
-			raid.Hatched = true
-			needUpdate = true
+	}
+	if raid.IsEx() {
+		for _, rem := range exReminders {
+			if !raid.reminded(rem.name) {
+				bs.sched.Schedule(Event{When: raid.HatchTime().Add(-rem.lead), Kind: EventReminder,
+					RaidID: id, Group: -1, Lead: int(rem.lead / time.Minute)})
+			}
 		}
+	}
+}
 
-		if t.After(raid.EndTime) {
-			raid.Expire(s)
-			delete(bs.Raids, k)
-			delete(bs.activeMessages, raid.RequestMsgID)
-			bs.dirty = true
-		} else if needUpdate {
-			raid.SendUpdate(s)
+// stop tracking a raid
+func (bs *BotState) removeRaid(raid *Raid) {
+	bs.sched.CancelRaid(raid.MessageID)
+	bs.mut.Lock()
+	delete(bs.Raids, raid.MessageID)
+	delete(bs.activeMessages, raid.RequestMsgID)
+	bs.mut.Unlock()
+	bs.recordRemoved(raid.MessageID)
+}
+
+func (bs *BotState) handleEvent(e Event) {
+	t := bs.clock.Now()
+
+	switch e.Kind {
+	case EventSave:
+		bs.expirePrompts()
+		bs.saveIfDirty()
+		bs.sched.Schedule(Event{When: t.Add(saveInterval), Kind: EventSave, Group: -1})
+		return
+	case EventOutcomeClose:
+		bs.closeOutcome(e.RaidID)
+		return
+	}
+
+	var ask *outcomeAsk
+	bs.withRaid(e.RaidID, func(raid *Raid, out *deferred) {
+		var rg *Group
+		if e.Group > 0 {
+			rg = raid.group(e.Group)
+		}
+		switch e.Kind {
+		case EventHatch:
+			if !raid.Hatched {
+				raid.Hatched = true
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			}
+		case EventGroupStart:
+			if rg != nil && !rg.Expired {
+				ask = newOutcomeAsk(raid, rg)
+				rg.Expire(out, bs.notifier(out))
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			}
+		case EventReminder:
+			if rg == nil {
+				if raid.Remind(bs.notifier(out), t) {
+					bs.recordRaid(raid)
+				}
+			} else if rg.dueReminders(bs.reminderLeads(raid.GuildID), t) && t.Before(rg.StartTime) {
+				bs.sendGroupReminder(out, rg, t)
+				bs.recordRaid(raid)
+			}
+		case EventInvites:
+			if rg != nil && bs.sendInvites(out, rg) {
+				bs.recordRaid(raid)
+			}
+		case EventRaidEnd:
+			raid.Expire(out)
+			bs.updatePost(out, raid)
+			bs.archiveRaid(raid)
+			bs.removeRaid(raid)
 		}
+	})
+	if ask != nil {
+		bs.askOutcome(ask)
 	}
 }
 
+// point raids at gym from onto gym to, e.g. after merging gyms; returns the
+// number of raids changed
+func (bs *BotState) repointRaids(from, to *gymdb.Gym) int {
+	n := 0
+	for _, raid := range bs.raidList() {
+		bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+			if raid.Gym.Id != from.Id {
+				return
+			}
+			raid.Gym = to
+			bs.updatePost(out, raid)
+			bs.recordRaid(raid)
+			n++
+		})
+	}
+	return n
+}
+
 func NewBotState(dg *discordgo.Session, snapshotPath string, gympath string) *BotState {
+	bs := newBotState(dg, realClock{}, snapshotPath, gympath)
+
+	// Register the messageCreate func as a callback for MessageCreate events.
+	dg.AddHandler(bs.readyHandler)
+	dg.AddHandler(bs.messageCreate)
+	dg.AddHandler(bs.messageEdit)
+	dg.AddHandler(bs.messageDelete)
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+		if m.UserID != s.State.User.ID {
+			bs.messageReactionAdd(s, m)
+		}
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageReactionRemove) {
+		if m.UserID != s.State.User.ID {
+			bs.messageReactionRemove(s, m)
+		}
+	})
+
+	go bs.sched.Run()
+	go bs.outbox.Run()
+
+	return bs
+}
+
+// load the state and schedule its events, without connecting to discord or
+// starting the scheduler
+func newBotState(s Session, clock Clock, snapshotPath string, gympath string) *BotState {
 	bs := &BotState{
 		emojiMap:     make(map[string]string),
 		channelCache: make(map[string]string),
 		gymdb:        gymdb.NewGymDB(gympath),
 
 		Raids:            make(map[string]*Raid),
-		channelCallbacks: make(map[string]func(*discordgo.Session, *discordgo.MessageCreate)),
-		activeMessages:   make(map[string]ActiveMessage),
+		Users:          make(map[string]*UserSettings),
+		Prompts:        make(map[string][]*Prompt),
+		Outcomes:       make(map[string]*OutcomeRecord),
+		activeMessages: make(map[string]ActiveMessage),
+
+		session:      s,
+		clock:        clock,
+		snapshotPath: snapshotPath,
 	}
+	bs.outbox = NewOutbox(s)
+	bs.sched = NewScheduler(clock, bs.handleEvent)
 
+	emojiMut.Lock()
 	globalEmojiMap = bs.emojiMap
+	emojiMut.Unlock()
 
 	bs.Load(snapshotPath)
+	journal, err := store.OpenJournal(snapshotPath + journalSuffix)
+	if err != nil {
+		// carry on; changes are saved with the next snapshot
+		log.Print(err)
+	} else {
+		bs.journal = journal
+	}
+	history, err := OpenHistory(snapshotPath + historySuffix)
+	if err != nil {
+		// carry on without stats
+		log.Print(err)
+	} else {
+		bs.history = history
+	}
 
-	// Register the messageCreate func as a callback for MessageCreate events.
-	dg.AddHandler(bs.readyHandler)
-	dg.AddHandler(bs.messageCreate)
-	dg.AddHandler(bs.messageEdit)
-	dg.AddHandler(bs.messageDelete)
-	dg.AddHandler(bs.messageReactionAdd)
-	dg.AddHandler(bs.messageReactionRemove)
-
-	bs.expireTicker = time.NewTicker(10 * time.Second)
-	go func(t *time.Ticker) {
-		for range t.C {
-			bs.ExpireOld(dg, time.Now())
-			if bs.dirty {
-				bs.Save(snapshotPath)
-				bs.dirty = false
-			}
+	for _, raid := range bs.Raids {
+		raid.mut.Lock()
+		bs.scheduleRaid(raid)
+		raid.mut.Unlock()
+		if raid.RequestMsgID != "" {
+			bs.activeMessages[raid.RequestMsgID] = &Request{raid}
 		}
-	}(bs.expireTicker)
+	}
+	for id, o := range bs.Outcomes {
+		bs.activeMessages[id] = &Outcome{o}
+		bs.sched.Schedule(Event{When: o.Closes, Kind: EventOutcomeClose, RaidID: id, Group: -1})
+	}
+	bs.sched.Schedule(Event{When: clock.Now().Add(saveInterval), Kind: EventSave, Group: -1})
 
 	return bs
 }
 
 func (bs *BotState) Stop() {
-	bs.expireTicker.Stop()
+	bs.sched.Stop()
+	bs.outbox.Stop()
+	bs.saveIfDirty()
+	if bs.journal != nil {
+		bs.journal.Close()
+	}
+	if bs.history != nil {
+		bs.history.Close()
+	}
 }
 
 func (bs *BotState) readyHandler(s *discordgo.Session, r *discordgo.Ready) {
@@ -186,41 +533,39 @@ func (bs *BotState) readyHandler(s *discordgo.Session, r *discordgo.Ready) {
 			log.Println(err)
 			continue
 		}
+		emojiMut.Lock()
 		for _, emoji := range g.Emojis {
 			bs.emojiMap[emoji.Name] = emoji.ID
 		}
+		emojiMut.Unlock()
 	}
 	log.Println()
-}
 
-func (bs *BotState) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) {
-	if m.UserID == s.State.User.ID {
-		return
-	}
+	// catch up on anything that changed while we were down
+	bs.reconcile(s)
+}
 
+func (bs *BotState) messageReactionRemove(s Session, m *discordgo.MessageReactionRemove) {
 	log.Printf("messageid %s %s reaction removed: %s(%s)", m.MessageID, m.UserID, m.Emoji.ID, m.Emoji.Name)
 
-	if m.Emoji.Name[1:] == boxEmoji {
-		n := m.Emoji.Name[0] - '1'
-		bs.mut.Lock()
-		defer bs.mut.Unlock()
-		raid, ok := bs.Raids[m.MessageID]
-		if !ok {
-			return
-		}
+	if msg, ok := bs.activeMessage(m.MessageID); ok {
+		msg.OnReactionRemove(bs, s, m)
+		return
+	}
 
-		if n < 0 || n >= uint8(len(raid.Groups)) {
-			return
-		}
-		rg := raid.Groups[n]
-		if rg.Expired {
-			return
-		}
-		delete(rg.Members, m.UserID)
+	if n, ok := emojiGroup(m.Emoji.Name); ok {
+		bs.withRaid(m.MessageID, func(raid *Raid, out *deferred) {
+			rg := raid.group(n)
+			if rg == nil || rg.Expired {
+				return
+			}
+			rg.leave(m.UserID)
+			bs.promoteWaitlist(out, rg)
 
-		log.Printf("removing %s from raidgroup %s", m.UserID, rg.String())
-		raid.SendUpdate(s)
-		bs.dirty = true
+			log.Printf("removing %s from raidgroup %s", m.UserID, rg.String())
+			bs.updatePost(out, raid)
+			bs.recordRaid(raid)
+		})
 		return
 	}
 }
@@ -236,178 +581,200 @@ func (bs *BotState) messageDelete(s *discordgo.Session, m *discordgo.MessageDele
 		return
 	}
 
-	bs.mut.Lock()
-	raid, raidok := bs.Raids[m.ID]
-	bs.mut.Unlock()
-	if raidok {
-		log.Printf("Deleting raid %s", raid.String())
-		for _, rg := range raid.Groups {
-			rg.Cancel(s)
-		}
-		bs.mut.Lock()
-		delete(bs.Raids, m.Message.ID)
-		bs.dirty = true
-		bs.mut.Unlock()
+	if raid, ok := bs.raid(m.ID); ok {
+		bs.cancelRaid(raid, "raid post deleted")
 	}
+}
 
+// the guild a channel's in, or "" for DMs and channels that can't be found
+func channelGuild(s *discordgo.Session, channelID string) string {
+	ch, err := s.State.Channel(channelID)
+	if err != nil || ch == nil {
+		return ""
+	}
+	return ch.GuildID
 }
 
-func (bs *BotState) userChannel(s *discordgo.Session, userID string) (string, error) {
+func (bs *BotState) userChannel(s Session, userID string) (string, error) {
+	bs.mut.Lock()
 	chanId, ok := bs.channelCache[userID]
+	bs.mut.Unlock()
 	if !ok {
 		userchan, err := s.UserChannelCreate(userID)
-		log.Printf("created user channel for %s -> %s", userchan.Name, userchan.ID)
 		if err != nil {
 			return "", err
 		}
+		log.Printf("created user channel for %s -> %s", userchan.Name, userchan.ID)
+		bs.mut.Lock()
 		bs.channelCache[userID] = userchan.ID
+		bs.mut.Unlock()
 		chanId = userchan.ID
 	}
 	return chanId, nil
 }
 
-func (bs *BotState) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
-	if m.UserID == s.State.User.ID {
+func (bs *BotState) messageReactionAdd(s Session, m *discordgo.MessageReactionAdd) {
+	log.Printf("reaction add: %s %s %s %s(%s)\n", m.ChannelID, m.MessageID, m.UserID, m.Emoji.ID, m.Emoji.Name)
+
+	if msg, ok := bs.activeMessage(m.MessageID); ok {
+		msg.OnReactionAdd(bs, s, m)
 		return
 	}
 
-	log.Printf("reaction add: %s %s %s %s(%s)\n", m.ChannelID, m.MessageID, m.UserID, m.Emoji.ID, m.Emoji.Name)
-
 	if m.Emoji.Name == "⏰" {
-		bs.mut.Lock()
-		defer bs.mut.Unlock()
-
-		raid, ok := bs.Raids[m.MessageID]
+		raid, ok := bs.raid(m.MessageID)
 		if !ok {
 			log.Print("...not raid")
 			return
 		}
+		raid.mut.Lock()
 		log.Print(raid.String())
+		gymName := raid.Gym.Name
+		raid.mut.Unlock()
 
 		// remove the reaction once processed
 		s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
 
-		ch, err := bs.userChannel(s, m.UserID)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		_, err = s.ChannelMessageSend(ch, fmt.Sprintf("Adding a raid group for %s; what time?",
-			raid.Gym.Name))
-		if err != nil {
-			log.Println(err)
-			return
-		}
+		bs.promptGroupTime(s, m.UserID, raid, gymName)
+		return
+	}
 
-		bs.channelCallbacks[ch] = func(s *discordgo.Session, privm *discordgo.MessageCreate) {
-			if privm.Author.ID != m.UserID {
-				log.Printf("??? private chat w/ %s but got userid %s?", m.UserID, privm.Author.ID)
-				return
-			}
-			// parse the time
-			log.Printf("got time from %s for raid %s: %s", privm.Author.Username, raid.String(), privm.Content)
-			t, err := fuzzyTime(privm.Content, time.Now())
-			if err != nil {
-				s.ChannelMessageSend(privm.ChannelID, "Couldn't understand time "+privm.Content)
-				log.Printf("can't parse time %s: %s", privm.Content, err)
+	if n, ok := emojiGroup(m.Emoji.Name); ok {
+		bs.withRaid(m.MessageID, func(raid *Raid, out *deferred) {
+			rg := raid.group(n)
+			if rg == nil || rg.Expired {
 				return
 			}
-			if t.Before(time.Now()) {
-				s.ChannelMessageSend(privm.ChannelID, fmt.Sprintf(
-					"%s is in the past!", t.Format("3:04 PM")))
+			raid.pick(m.UserID, rg)
+			if !rg.join(m.UserID, bs.userSettings(m.UserID).Team, bs.limits(raid.GuildID).GroupCap) {
+				bs.notify(out, m.ChannelID, map[string]int{m.UserID: 1}, fmt.Sprintf("group %d for the %s is full; you're number %d on the waitlist",
+					rg.Number, raid.String(), rg.waiter(m.UserID)+1))
 			}
-			if t.After(raid.EndTime) {
-				s.ChannelMessageSend(privm.ChannelID, fmt.Sprintf(
-					"%s is after the raid ends (at %s)!",
-					t.Format("3:04 PM"), raid.EndTime.Format("3:04PM")))
-				return
-			}
-
-			rg := raid.AddGroup(t, s)
-			bs.dirty = true
-			s.ChannelMessageSend(privm.ChannelID, "Got it! Created "+rg.String())
-			// once a successful interaction has occurred, remove this callback
-			delete(bs.channelCallbacks, privm.ChannelID)
-		}
+			bs.sendInvites(out, rg)
 
+			log.Printf("adding %s to raidgroup %s", m.UserID, rg.String())
+			bs.updatePost(out, raid)
+			bs.recordRaid(raid)
+		})
 		return
 	}
 
-	if m.Emoji.Name[1:] == boxEmoji {
-		n := m.Emoji.Name[0] - '1'
+	// change how the user's joining their groups
+	if mode, ok := emojiMode(m.Emoji.Name); ok {
+		bs.withRaid(m.MessageID, func(raid *Raid, out *deferred) {
+			// remove the reaction once processed
+			out.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
 
-		bs.mut.Lock()
-		defer bs.mut.Unlock()
-
-		raid, ok := bs.Raids[m.MessageID]
-		if !ok {
-			return
-		}
-
-		if n < 0 || n >= uint8(len(raid.Groups)) {
-			return
-		}
-		rg := raid.Groups[n]
-		if rg.Expired {
-			return
-		}
-		rg.Members[m.UserID] = 1
-
-		log.Printf("adding %s to raidgroup %s", m.UserID, rg.String())
-		raid.SendUpdate(s)
-		bs.dirty = true
+			dirty := false
+			for _, rg := range raid.Groups {
+				if _, ok := rg.Members[m.UserID]; !ok || rg.Expired || rg.Modes[m.UserID] == mode {
+					continue
+				}
+				if err := rg.setMode(m.UserID, mode, bs.limits(raid.GuildID).RemoteCap); err == ErrRemoteFull {
+					bs.notify(out, m.ChannelID, map[string]int{m.UserID: 1}, fmt.Sprintf("group %d for the %s already has %d remote players",
+						rg.Number, raid.String(), rg.remoteAccounts()))
+					continue
+				}
+				bs.sendInvites(out, rg)
+				dirty = true
+			}
+			if dirty {
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			}
+		})
 		return
 	}
 
-	// add/subtract extras
-	if m.Emoji.Name == "➕" || m.Emoji.Name == "➖" {
-		plus := m.Emoji.Name == "➕"
-		bs.mut.Lock()
-		defer bs.mut.Unlock()
-
-		raid, ok := bs.Raids[m.MessageID]
-		if !ok {
-			return
-		}
-
-		// remove the reaction once processed
-		s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
-
-		dirty := false
-		for _, rg := range raid.Groups {
-			if rg.Expired {
-				continue
+	// add/subtract extras, maybe on a team, in the group the user joined
+	// last
+	team, heart := heartTeam(m.Emoji.Name)
+	if m.Emoji.Name == "➕" || m.Emoji.Name == "➖" || heart {
+		plus := m.Emoji.Name != "➖"
+		bs.withRaid(m.MessageID, func(raid *Raid, out *deferred) {
+			// remove the reaction once processed
+			out.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+
+			rg := raid.extrasGroup(m.UserID)
+			if rg == nil {
+				return
 			}
-			if _, ok := rg.Members[m.UserID]; ok {
-				if plus {
-					rg.Members[m.UserID]++
-					dirty = true
-				} else if rg.Members[m.UserID] > 1 {
-					rg.Members[m.UserID]--
-					dirty = true
-				}
+			dirty := false
+			if plus && !rg.canAddAccount(m.UserID, bs.limits(raid.GuildID).RemoteCap) {
+				bs.notify(out, m.ChannelID, map[string]int{m.UserID: 1}, fmt.Sprintf("group %d for the %s already has %d remote players",
+					rg.Number, raid.String(), rg.remoteAccounts()))
+			} else if plus {
+				dirty = rg.addExtra(m.UserID, team, bs.limits(raid.GuildID).GroupCap)
+			} else if rg.removeExtra(m.UserID) {
+				bs.promoteWaitlist(out, rg)
+				dirty = true
 			}
-		}
-		if dirty {
-			raid.SendUpdate(s)
-		}
+			if dirty {
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			}
+		})
 	}
 
 	// all other custom emojis
 	if m.Emoji.ID != "" {
-		bs.mut.Lock()
-		defer bs.mut.Unlock()
-		raid, ok := bs.Raids[m.MessageID]
-		if !ok {
-			return
-		}
+		bs.withRaid(m.MessageID, func(raid *Raid, out *deferred) {
+			raid.Emoji = "<:" + m.Emoji.Name + ":" + m.Emoji.ID + "> "
+			log.Printf("changing raid emoji: %s", raid.String())
+			bs.updatePost(out, raid)
+			bs.recordRaid(raid)
+		})
+	}
+}
 
-		raid.Emoji = "<:" + m.Emoji.Name + ":" + m.Emoji.ID + "> "
-		log.Printf("changing raid emoji: %s", raid.String())
-		raid.SendUpdate(s)
-		bs.dirty = true
+// parse a group start time for a raid, telling channelID if it's no good;
+// must hold raid.mut
+func (bs *BotState) groupTime(out *deferred, raid *Raid, channelID, text string) (time.Time, bool) {
+	// EX raid groups are on the day of the raid
+	timebase := bs.clock.Now()
+	if raid.IsEx() {
+		timebase = raid.HatchTime()
+	}
+	t, err := fuzzyTime(text, timebase)
+	if err != nil {
+		out.ChannelMessageSend(channelID, "Couldn't understand time "+text)
+		log.Printf("can't parse time %s: %s", text, err)
+		return t, false
+	}
+	if t.Before(bs.clock.Now()) {
+		out.ChannelMessageSend(channelID, fmt.Sprintf(
+			"%s is in the past!", t.Format("3:04 PM")))
+	}
+	if t.After(raid.EndTime) {
+		out.ChannelMessageSend(channelID, fmt.Sprintf(
+			"%s is after the raid ends (at %s)!",
+			t.Format("3:04 PM"), raid.EndTime.Format("3:04PM")))
+		return t, false
+	}
+	return t, true
+}
 
+// add a group for userID at the time DMed in reply to ⏰; must hold
+// raid.mut. Returns whether the group was added.
+func (bs *BotState) addGroupFromDM(out *deferred, raid *Raid, userID, channelID, text string) bool {
+	t, ok := bs.groupTime(out, raid, channelID, text)
+	if !ok {
+		return false
 	}
+	if err := raid.canAddGroup(); err != nil {
+		out.ChannelMessageSend(channelID, "Sorry, "+err.Error())
+		return false
+	}
+
+	rg := raid.AddGroup(t, out)
+	rg.Creator = userID
+	bs.updatePost(out, raid)
+	// no reminders for a group starting sooner than that
+	rg.dueReminders(bs.reminderLeads(raid.GuildID), bs.clock.Now())
+	bs.scheduleRaid(raid)
+	bs.recordRaid(raid)
+	out.ChannelMessageSend(channelID, "Got it! Created "+rg.String())
+	return true
 }
 
 func (bs *BotState) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -417,13 +784,9 @@ func (bs *BotState) messageCreate(s *discordgo.Session, m *discordgo.MessageCrea
 		return
 	}
 
-	bs.mut.Lock()
-	if cb, ok := bs.channelCallbacks[m.ChannelID]; ok {
-		bs.mut.Unlock()
-		cb(s, m)
+	if bs.promptReply(m) {
 		return
 	}
-	bs.mut.Unlock()
 
 	log.Printf("%s %s %s(%s): %s\n", m.Timestamp, m.ChannelID, m.Author.Username,
 		m.Author.Email, m.ContentWithMentionsReplaced())
@@ -438,37 +801,79 @@ func (bs *BotState) maybeProcessCommand(s *discordgo.Session, m *discordgo.Messa
 	if len(splitMsg) == 0 {
 		return
 	}
+	query := ""
+	if len(splitMsg) > 1 {
+		query = splitMsg[1]
+	}
 
 	switch splitMsg[0] {
 	case "info":
-		bs.infoCommand(s, m, splitMsg[1])
+		bs.infoCommand(s, m, query)
 	case "raid":
-		bs.raidCommand(s, m, splitMsg[1])
+		bs.raidCommand(s, m, query)
+	case "exraid":
+		bs.exraidCommand(s, m, query)
+	case "reminders":
+		bs.remindersCommand(s, m, query)
+	case "notify":
+		bs.notifyCommand(s, m, query)
+	case "team":
+		bs.teamCommand(s, m, query)
+	case "remotecap":
+		bs.remoteCapCommand(s, m, query)
+	case "groupcap":
+		bs.groupCapCommand(s, m, query)
+	case "group":
+		bs.groupCommand(s, m, query)
+	case "profile":
+		bs.profileCommand(s, m, query)
+	case "stats":
+		bs.statsCommand(s, m, query)
 	case "raidhelp":
 		_, err := s.ChannelMessageSend(m.ChannelID, "Syntax:\n"+
-			"`!info <gym name>` - get gym name and location\n"+
+			"`!info [--all] <gym name> [ex:yes] [tag:park] [key=value]` - get gym name and location; --all includes disabled gyms\n"+
+			"`!cell <lat,lon|gym name> [level]` - show the S2 cell (default level 14) and the gyms in it\n"+
 			"`!raid <pokemon> <gym name> ends/hatches [at 10:00pm/in 1h20m] [starts at 9:45pm]` - start a raid\n"+
+			"`!exraid <gym name> <date> <time>` - post an EX raid, e.g. `!exraid denker jun 18 4:00pm`\n"+
+			"`!notify [mention|dm|off]` - how to tell you about your raid groups: reminders, starts, changes and open spots\n"+
+			"`!notify quiet <from>-<to>|off` - hours not to, e.g. `!notify quiet 10pm-7am`\n"+
+			"`!reminders leads <minutes...>|none` - when to send the server's group reminders, e.g. `!reminders leads 10 2` (moderators)\n"+
+			"`!team [mystic|valor|instinct|none]` - set your team, or take it from your team role\n"+
+			"`!profile set name|level|code|private <value>` - your trainer name, level and friend code, given to hosts of your remote raids (unless private)\n"+
+			"`!group move|cancel|note <raid> <group number> [time|text]` - reschedule, cancel or add a note to a group you created\n"+
+			"`!remotecap [n]`, `!remotecap <raid> <group number> <n|default>` - how many remote players groups take, set by moderators or, for one group, its creator\n"+
+			"`!groupcap [n]`, `!groupcap <raid> <group number> <n|default>` - how many accounts groups take, set by moderators or, for one group, its creator; the rest wait for a spot\n"+
+			"`!stats me`, `!stats gym <gym name>`, `!stats top [day|week|month|year|all]` - raid history and leaderboards\n"+
+			"`!stats boss [pokemon]` - how raids against each boss have gone\n"+
 			"Gym names are free-form text, fuzzy matched. Use !info to check whether I have the right one.\n"+
 			"Editing or deleting your message requesting the raid will edit / cancel the raid.")
 		if err != nil {
 			log.Print(err)
 		}
 	case "dumpstate":
-		m, err := json.Marshal(&bs)
+		m, err := bs.marshal()
 		if err != nil {
 			log.Print(err)
 		}
 		log.Print(string(m))
+		log.Printf("outbound queue depth %d", bs.outbox.Depth())
+	case "cell":
+		bs.cellCommand(s, m, query)
 	case "scan":
-		bs.scanCommand(s, m, splitMsg[1])
+		bs.scanCommand(s, m, query)
 	case "gym":
-		bs.gymCommand(s, m, splitMsg[1])
+		bs.gymCommand(s, m, query)
 	case "gymhelp":
 		_, err := s.ChannelMessageSend(m.ChannelID, "Syntax:\n"+
 			"`!gym new <lat,lon> <Gym Name>` - Create a new gym\n"+
 			"`!gym edit <gym name/id> name <New Name>` - Rename a gym\n"+
 			"`!gym edit <gym name/id> location <lat,lon>` - Move a gym\n"+
-			"`!gym remove <gym name/id>` - Remove a gym")
+			"`!gym edit <gym name/id> set <ex|tags|notes|key> [value]` - Set or clear gym info\n"+
+			"`!gym remove [--hard] <gym name/id>` - Disable a gym, or delete it with --hard\n"+
+			"`!gym enable|disable <gym name/id>` - Enable or disable a gym\n"+
+			"`!gym export <csv|geojson|kml|gpx>` - Download the gym DB\n"+
+			"`!gym dupes [meters]` - List gyms that look like duplicates\n"+
+			"`!gym merge <keep id> <drop id>` - Merge a duplicate gym into another")
 		if err != nil {
 			log.Print(err)
 		}
@@ -498,4 +903,11 @@ func addGymEmbed(g *gymdb.Gym, msg *discordgo.MessageSend) {
 			Height: 200,
 		},
 	}
+	for _, kv := range g.Metadata() {
+		msg.Embed.Fields = append(msg.Embed.Fields, &discordgo.MessageEmbedField{
+			Name:   kv[0],
+			Value:  kv[1],
+			Inline: kv[0] != gymdb.MetaNotes,
+		})
+	}
 }
@@ -0,0 +1,470 @@
+package raid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "raidquaza")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// a BotState on a fake clock and discord session, with an empty snapshot
+func newTestBotState(t *testing.T) (*BotState, *FakeClock, *fakeSession, func()) {
+	dir, cleanup := tempDir(t)
+	t0, _ := time.Parse(time.RFC3339, "2018-05-28T15:27:30-07:00")
+	clock := NewFakeClock(t0)
+	s := &fakeSession{}
+	bs := newBotState(s, clock, filepath.Join(dir, "rqdata.json"), "../gymdb/gyms.txt")
+	return bs, clock, s, cleanup
+}
+
+// a Ho-Oh raid at Denker hatching in 30 minutes, posted as message id in
+// the "raids" channel and requested by message "req-<id>"
+func newTestRaid(t *testing.T, bs *BotState, id string) *Raid {
+	r := &Raid{MessageID: id, ChannelID: "raids", RequestMsgID: "req-" + id,
+		Request: "!raid ho-oh denker hatches in 30m"}
+	if err, _ := r.ParseRaidRequest("ho-oh denker hatches in 30m", bs.gymdb, bs.clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	bs.Raids[id] = r
+	return r
+}
+
+func TestBotState_RaidLifecycle(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+	advance := func(d time.Duration) {
+		clock.Advance(d)
+		bs.sched.RunDue(clock.Now())
+		bs.outbox.Flush()
+	}
+
+	r := newTestRaid(t, bs, "raid")
+	rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	rg.Members["user"] = 1
+	bs.scheduleRaid(r)
+
+	advance(29 * time.Minute)
+	if r.Hatched || len(s.sentWith("reminder")) != 0 {
+		t.Fatal("too early")
+	}
+	advance(time.Minute)
+	if !r.Hatched || len(s.sentWith("starts in 10 minutes")) != 1 {
+		t.Fatal(s.sent)
+	}
+	advance(8 * time.Minute)
+	if len(s.sentWith("starts in 2 minutes")) != 1 || rg.Expired {
+		t.Fatal(s.sent)
+	}
+	advance(2 * time.Minute)
+	if !rg.Expired || len(s.sentWith("starting now")) != 1 {
+		t.Fatal(s.sent)
+	}
+	advance(time.Hour)
+	if len(bs.Raids) != 0 || len(bs.sched.Pending()) != 1 {
+		t.Fatal(bs.Raids, bs.sched.Pending())
+	}
+	t.Log(s.sent)
+}
+
+func TestScheduler_RunFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	fired := make(chan Event, 1)
+	sc := NewScheduler(clock, func(e Event) {
+		fired <- e
+	})
+	go sc.Run()
+	defer sc.Stop()
+
+	sc.Schedule(Event{When: clock.Now().Add(time.Minute), Kind: EventSave, Group: -1})
+	// wait for the scheduler to set its timer
+	for {
+		clock.mut.Lock()
+		n := len(clock.timers)
+		clock.mut.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case e := <-fired:
+		t.Fatal("fired early", e)
+	default:
+	}
+	clock.Advance(time.Minute)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("didn't fire")
+	}
+}
+
+// reactions from many users at once, while the scheduler runs and the state
+// is saved; run with -race
+func TestBotState_ConcurrentReactions(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := newTestRaid(t, bs, "raid")
+	const users = 20
+	r.AddGroup(clock.Now().Add(40*time.Minute), s).Cap = 2 * users // room for everyone
+	r.AddGroup(clock.Now().Add(50*time.Minute), s)
+	bs.scheduleRaid(r)
+	go bs.outbox.Run()
+
+	reaction := func(user, emoji string) *discordgo.MessageReaction {
+		return &discordgo.MessageReaction{UserID: user, MessageID: "raid", ChannelID: "raids",
+			Emoji: discordgo.Emoji{Name: emoji}}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: reaction(user, "2"+boxEmoji)})
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: reaction(user, "1"+boxEmoji)})
+			// for group 1, the one joined last
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: reaction(user, "➕")})
+			bs.messageReactionRemove(s, &discordgo.MessageReactionRemove{MessageReaction: reaction(user, "2"+boxEmoji)})
+		}(fmt.Sprint("user", i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 35; i++ {
+			clock.Advance(time.Minute)
+			bs.sched.RunDue(clock.Now())
+			bs.Save(bs.snapshotPath)
+		}
+	}()
+	wg.Wait()
+	bs.outbox.Stop()
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if n := r.Groups[0].Total(); n != 2*users {
+		t.Fatal("group 1 has", n)
+	}
+	if n := r.Groups[1].Total(); n != 0 {
+		t.Fatal("group 2 has", n)
+	}
+	// however the updates raced, the post is left showing the raid as it is
+	if last := s.edits[len(s.edits)-1]; last != "raid: "+r.GenMessage(bs.limits(r.GuildID)) {
+		t.Fatal("stale post", last)
+	}
+}
+
+// changes are journaled as they happen, so a crash before the next snapshot
+// loses nothing
+func TestBotState_Recovery(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := newTestRaid(t, bs, "raid")
+	r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	bs.recordRaid(r)
+	bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID: "user", MessageID: "raid", ChannelID: "raids", Emoji: discordgo.Emoji{Name: "1" + boxEmoji}}})
+	bs.setUserSettings("user", UserSettings{Notify: NotifyDM})
+
+	// "crash" without saving a snapshot
+	bs.journal.Close()
+	reloaded := newBotState(s, clock, bs.snapshotPath, "../gymdb/gyms.txt")
+	r2, ok := reloaded.Raids["raid"]
+	if !ok || r2.Groups[0].Members["user"] != 1 || reloaded.userSettings("user").Notify != NotifyDM {
+		t.Fatal(reloaded.Raids, reloaded.Users)
+	}
+
+	// compacting leaves the state the same
+	reloaded.removeRaid(r2)
+	if err := reloaded.Save(reloaded.snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Stop()
+	if fi, err := os.Stat(bs.snapshotPath + journalSuffix); err != nil || fi.Size() != 0 {
+		t.Fatal("journal not compacted", fi, err)
+	}
+	reloaded = newBotState(s, clock, bs.snapshotPath, "../gymdb/gyms.txt")
+	if len(reloaded.Raids) != 0 || reloaded.userSettings("user").Notify != NotifyDM {
+		t.Fatal(reloaded.Raids, reloaded.Users)
+	}
+}
+
+// reactions, edits and deletions made while the bot was down are picked up
+// after a restart
+func TestBotState_Reconcile(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	bot := &discordgo.User{ID: "bot"}
+	s.messages = make(map[string]*discordgo.Message)
+	s.reactions = make(map[string][]*discordgo.User)
+	for _, id := range []string{"kept", "nopost", "noreq"} {
+		r := newTestRaid(t, bs, id)
+		rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+		rg.Members["a"] = 2
+		rg.Members["b"] = 1
+		bs.recordRaid(r)
+		if id != "nopost" {
+			s.messages[id] = &discordgo.Message{ID: id, Author: bot}
+		}
+		if id != "noreq" {
+			s.messages["req-"+id] = &discordgo.Message{ID: "req-" + id, Content: r.Request}
+		}
+	}
+	bs.journal.Close()
+
+	// while we're away: b leaves, c joins and the request is edited
+	s.reactions["kept1"+boxEmoji] = []*discordgo.User{bot, {ID: "a"}, {ID: "c"}}
+	edited := clock.Now().Add(5 * time.Minute)
+	s.messages["req-kept"].Content = "!raid ho-oh denker hatches in 20m"
+	s.messages["req-kept"].EditedTimestamp = discordgo.Timestamp(edited.Format(time.RFC3339))
+	clock.Advance(10 * time.Minute)
+
+	reloaded := newBotState(s, clock, bs.snapshotPath, "../gymdb/gyms.txt")
+	defer reloaded.Stop()
+	if _, ok := reloaded.activeMessages["req-kept"].(*Request); !ok {
+		t.Fatal("request not registered", reloaded.activeMessages)
+	}
+	reloaded.reconcile(s)
+	reloaded.outbox.Flush()
+
+	if len(reloaded.Raids) != 1 {
+		t.Fatal("vanished raids not removed", reloaded.Raids)
+	}
+	r := reloaded.Raids["kept"]
+	members := r.Groups[0].Members
+	if len(members) != 2 || members["a"] != 2 || members["c"] != 1 {
+		t.Fatal("members not rebuilt", members)
+	}
+	if !r.HatchTime().Equal(edited.Add(20 * time.Minute)) {
+		t.Fatal("edit not applied", r.HatchTime())
+	}
+	if len(s.sentWith("was cancelled")) != 2 || len(s.deleted) != 1 || s.deleted[0] != "noreq" {
+		t.Fatal(s.sent, s.deleted)
+	}
+}
+
+// ⏰ on two raids, choosing between them, surviving a restart, and timing out
+func TestBotState_Prompts(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	var raids []*Raid
+	for _, id := range []string{"raid1", "raid2"} {
+		r := newTestRaid(t, bs, id)
+		bs.recordRaid(r)
+		raids = append(raids, r)
+		bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+			UserID: "user", MessageID: id, ChannelID: "raids", Emoji: discordgo.Emoji{Name: "⏰"}}})
+	}
+	dm := func(bs *BotState, content string) {
+		if !bs.promptReply(&discordgo.MessageCreate{Message: &discordgo.Message{
+			ChannelID: "dm-user", Content: content, Author: &discordgo.User{ID: "user"}}}) {
+			t.Fatal("not handled:", content)
+		}
+		bs.outbox.Flush()
+	}
+
+	dm(bs, "4:00pm")
+	if len(s.sentWith("Which raid?")) != 1 || len(raids[0].Groups)+len(raids[1].Groups) != 0 {
+		t.Fatal("should ask which raid", s.sent)
+	}
+	dm(bs, "2")
+	if len(s.sentWith("What time")) != 1 {
+		t.Fatal("should ask for a time", s.sent)
+	}
+
+	// "crash" and answer after restarting
+	bs.journal.Close()
+	reloaded := newBotState(s, clock, bs.snapshotPath, "../gymdb/gyms.txt")
+	defer reloaded.Stop()
+	dm(reloaded, "4:00pm")
+	if len(reloaded.Raids["raid1"].Groups) != 0 || len(reloaded.Raids["raid2"].Groups) != 1 {
+		t.Fatal("group not added to raid2", s.sent)
+	}
+	dm(reloaded, "cancel")
+	if len(reloaded.Prompts) != 0 || reloaded.promptReply(&discordgo.MessageCreate{Message: &discordgo.Message{
+		ChannelID: "dm-user", Content: "4:00pm", Author: &discordgo.User{ID: "user"}}}) {
+		t.Fatal("prompts not cancelled", reloaded.Prompts)
+	}
+
+	reloaded.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID: "user", MessageID: "raid1", ChannelID: "raids", Emoji: discordgo.Emoji{Name: "⏰"}}})
+	clock.Advance(promptTimeout + time.Minute)
+	dm(reloaded, "4:30pm")
+	if len(s.sentWith("took too long")) != 1 || len(reloaded.Raids["raid1"].Groups) != 0 {
+		t.Fatal("prompt didn't expire", s.sent)
+	}
+
+	// commands aren't answers, and prompts left unanswered are dropped
+	reloaded.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID: "user", MessageID: "raid1", ChannelID: "raids", Emoji: discordgo.Emoji{Name: "⏰"}}})
+	if reloaded.promptReply(&discordgo.MessageCreate{Message: &discordgo.Message{
+		ChannelID: "dm-user", Content: "!notify dm", Author: &discordgo.User{ID: "user"}}}) {
+		t.Fatal("command taken as an answer")
+	}
+	clock.Advance(promptTimeout + time.Minute)
+	reloaded.sched.RunDue(clock.Now())
+	if len(reloaded.Prompts) != 0 {
+		t.Fatal("expired prompt kept", reloaded.Prompts)
+	}
+}
+
+// groups past 9 are joined with 🔟 and letters, ➕ counts in the group joined
+// last, and removing a group leaves the others' numbers alone
+func TestBotState_ManyGroups(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	for n := 1; n <= maxGroups; n++ {
+		if got, ok := emojiGroup(groupEmoji(n)); !ok || got != n {
+			t.Fatal(n, groupEmoji(n), got)
+		}
+	}
+	if _, ok := emojiGroup("➕"); ok {
+		t.Fatal("➕ isn't a group")
+	}
+
+	r := newTestRaid(t, bs, "raid")
+	// added latest first; they're shown in time order
+	for n := 11; n > 1; n-- {
+		r.AddGroup(clock.Now().Add(time.Duration(30+n)*time.Minute), s)
+	}
+	if r.canAddGroup() == nil {
+		t.Fatal("too many groups")
+	}
+	// an expired group makes room for another, with its reactions gone
+	n := s.removals
+	r.group(1).Expire(s, bs.notifier(&deferred{}))
+	if s.removals == n {
+		t.Fatal("expired group kept its reaction")
+	}
+	r.AddGroup(clock.Now().Add(31*time.Minute), s)
+	if r.canAddGroup() == nil || r.Groups[0].Number != 11 || r.Groups[10].Number != 1 {
+		t.Fatal("groups out of order")
+	}
+	bs.scheduleRaid(r)
+
+	react := func(user, emoji string, add bool) {
+		mr := &discordgo.MessageReaction{UserID: user, MessageID: "raid", ChannelID: "raids", Emoji: discordgo.Emoji{Name: emoji}}
+		if add {
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: mr})
+		} else {
+			bs.messageReactionRemove(s, &discordgo.MessageReactionRemove{MessageReaction: mr})
+		}
+	}
+	react("alice", "🔟", true)
+	react("alice", "🇦", true)
+	react("alice", "➕", true)
+	react("alice", "➕", true)
+	react("bob", "2"+boxEmoji, true)
+	ten, eleven := r.group(10), r.group(11)
+	if ten.Members["alice"] != 1 || eleven.Members["alice"] != 3 {
+		t.Fatal("extras in the wrong group", ten.Members, eleven.Members)
+	}
+
+	r.mut.Lock()
+	out := &deferred{}
+	r.RemoveGroup(r.group(2), out, bs.notifier(out))
+	r.mut.Unlock()
+	out.flush(bs.outbox)
+	bs.outbox.Flush()
+	react("alice", "🇦", false)
+	react("alice", "➖", true) // now for group 10
+	if r.group(2) != nil || len(s.sentWith("cancelled")) != 1 || len(eleven.Members) != 0 || ten.Members["alice"] != 1 {
+		t.Fatal("group not removed", s.sent)
+	}
+	t.Log(r.GenMessage(bs.limits(r.GuildID)))
+
+	// a group's events follow it, not its position
+	clock.Advance(32 * time.Minute)
+	bs.sched.RunDue(clock.Now())
+	if !ten.Expired || !eleven.Expired || r.group(9).Expired {
+		t.Fatal("wrong groups started")
+	}
+}
+
+// each guild's groups take its own number of accounts, and of remote ones;
+// a remote cap from before they were per guild goes for the rest
+func TestBotState_GroupCapByGuild(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	bs.GroupCaps = map[string]int{"small": 1}
+	bs.RemoteCaps = map[string]int{"small": 0, "": 5}
+	if bs.limits("small").RemoteCap != 0 || bs.limits("big").RemoteCap != 5 {
+		t.Fatal("wrong remote caps")
+	}
+	for _, guild := range []string{"small", "big"} {
+		r := newTestRaid(t, bs, guild)
+		r.GuildID = guild
+		r.AddGroup(clock.Now().Add(40*time.Minute), s)
+		for _, user := range []string{"alice", "bob"} {
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+				UserID: user, MessageID: guild, ChannelID: "raids", Emoji: discordgo.Emoji{Name: "1" + boxEmoji}}})
+		}
+	}
+	small, big := bs.Raids["small"].Groups[0], bs.Raids["big"].Groups[0]
+	if small.Total() != 1 || small.waiter("bob") != 0 || big.Total() != 2 {
+		t.Fatal(small.Members, small.Waitlist, big.Members)
+	}
+}
+
+// each guild's groups are reminded when it asked; leads from before they
+// were per guild go for the rest
+func TestBotState_ReminderLeadsByGuild(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	bs.ReminderLeads = map[string][]int{"quiet": {}, "": {15}}
+	for _, guild := range []string{"quiet", "other"} {
+		r := newTestRaid(t, bs, guild)
+		r.GuildID = guild
+		r.AddGroup(clock.Now().Add(40*time.Minute), s).Members[guild+"-user"] = 1
+		bs.scheduleRaid(r)
+	}
+	clock.Advance(39 * time.Minute)
+	bs.sched.RunDue(clock.Now())
+	bs.outbox.Flush()
+	if len(s.sentWith("<@quiet-user> reminder")) != 0 || len(s.sentWith("<@other-user> reminder: group 1")) != 1 {
+		t.Fatal("wrong reminders", s.sent)
+	}
+}
+
+// an EX raid posted less than a day ahead gets only the reminders still to
+// come
+func TestBotState_ExReminders(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := &Raid{MessageID: "raid", ChannelID: "raids"}
+	if err, _ := r.ParseExRaidRequest("denker may 29 1:00pm", bs.gymdb, clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	bs.Raids[r.MessageID] = r
+	r.AddGroup(r.HatchTime(), s).Members["user"] = 1
+	r.skipDueReminders(clock.Now())
+	bs.scheduleRaid(r)
+
+	bs.sched.RunDue(clock.Now())
+	clock.Advance(r.HatchTime().Sub(clock.Now()) - time.Hour)
+	bs.sched.RunDue(clock.Now())
+	bs.outbox.Flush()
+	if len(s.sentWith("tomorrow")) != 0 || len(s.sentWith("in an hour")) != 1 {
+		t.Fatal(s.sent)
+	}
+}
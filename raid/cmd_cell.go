@@ -0,0 +1,71 @@
+package raid
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"raidquaza/gymdb"
+	"raidquaza/s2"
+	"raidquaza/util"
+)
+
+const maxCellGymsShown = 10 // gyms listed by !cell
+
+func (bs *BotState) cellCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !cell <lat,lon> [level]
+	//  - !cell <gym name> [level]
+	tokens := strings.Fields(query)
+	if len(tokens) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!cell <lat,lon|gym name> [level]`")
+		return
+	}
+	level := gymdb.CountCellLevel
+	if n, err := strconv.Atoi(tokens[len(tokens)-1]); err == nil && len(tokens) > 1 {
+		if n < 0 || n > s2.MaxLevel {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> level must be 0 to %d",
+				m.Author.ID, s2.MaxLevel))
+			return
+		}
+		level = n
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var cell s2.CellID
+	var where string
+	lat, lon, n, err := util.ParseLatLong(tokens)
+	if err == nil && n == len(tokens) {
+		cell = s2.CellIDFromLatLng(lat, lon).Parent(level)
+		where = fmt.Sprintf("%f,%f", lat, lon)
+	} else {
+		gym := bs.findOneGym(s, m, strings.Join(tokens, " "))
+		if gym == nil {
+			return
+		}
+		cell = gym.CellID(level)
+		where = gym.Name
+	}
+
+	gyms := bs.gymdb.GymsInCell(cell)
+	clat, clon := cell.LatLng()
+	lines := []string{fmt.Sprintf("<@%s> %s is in level %d cell `%s` (center <https://www.google.com/maps/?q=%f,%f>) with %d gyms:",
+		m.Author.ID, where, level, cell.ToToken(), clat, clon, len(gyms))}
+	if len(gyms) > maxCellGymsShown {
+		lines = append(lines, formatGymMatches(gyms[:maxCellGymsShown], nil)...)
+		lines = append(lines, fmt.Sprintf("...and %d more", len(gyms)-maxCellGymsShown))
+	} else {
+		lines = append(lines, formatGymMatches(gyms, nil)...)
+	}
+	var neighbors []string
+	for _, n := range cell.Neighbors() {
+		neighbors = append(neighbors, fmt.Sprintf("`%s` (%d)", n.ToToken(), len(bs.gymdb.GymsInCell(n))))
+	}
+	lines = append(lines, "Neighboring cells (gyms): "+strings.Join(neighbors, " "))
+
+	_, err = s.ChannelMessageSend(m.ChannelID, strings.Join(lines, "\n"))
+	if err != nil {
+		log.Print(err)
+	}
+}
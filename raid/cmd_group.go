@@ -0,0 +1,136 @@
+package raid
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// the raid and group number at the start of args, and the args after them.
+// The raid is named by free text, so each split is tried until a raid
+// matches and is followed by a group number (or its reaction).
+func (bs *BotState) findGroup(args []string) (*Raid, int, []string, error) {
+	err := fmt.Errorf("which raid and group? e.g. `denker 2`")
+	for i := 1; i < len(args); i++ {
+		n, nerr := strconv.Atoi(args[i])
+		if nerr != nil {
+			var ok bool
+			if n, ok = emojiGroup(args[i]); !ok {
+				continue
+			}
+		}
+		raid, rerr := bs.findRaid(strings.Join(args[:i], " "))
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		return raid, n, args[i+1:], nil
+	}
+	return nil, 0, nil, err
+}
+
+// whether a user moderates a channel, and so can change anyone's groups
+func isModerator(s *discordgo.Session, userID, channelID string) bool {
+	perms, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return perms&(discordgo.PermissionManageMessages|discordgo.PermissionAdministrator) != 0
+}
+
+func (bs *BotState) groupCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !group move <raid> <n> <time>
+	//  - !group cancel <raid> <n>
+	//  - !group note <raid> <n> [text] - no text clears the note
+	usage := "<@" + m.Author.ID + "> use `!group move <raid> <group number> <time>`, " +
+		"`!group cancel <raid> <group number>` or `!group note <raid> <group number> [text]`"
+	args := strings.Fields(query)
+	if len(args) < 3 {
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+	raid, n, rest, err := bs.findGroup(args[1:])
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
+		return
+	}
+	text := strings.Join(rest, " ")
+	switch args[0] {
+	case "move":
+		if text == "" {
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+	case "cancel", "note":
+	default:
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+	bs.changeGroup(m.ChannelID, m.Author.ID, isModerator(s, m.Author.ID, raid.ChannelID),
+		raid, n, args[0], text)
+}
+
+// move a group to start at t, telling its members; its reminders and invites
+// are sent again for the new time. Must hold the raid's lock, and reschedule
+// the raid and update its post after.
+func (bs *BotState) moveGroup(out *deferred, rg *Group, t time.Time) {
+	was := rg.StartTime
+	rg.StartTime = t
+	rg.Reminded = nil
+	rg.Invited = nil
+	// no reminders for a group starting sooner than that
+	rg.dueReminders(bs.reminderLeads(rg.raid.GuildID), bs.clock.Now())
+	rg.raid.sortGroups()
+	bs.notify(out, rg.raid.ChannelID, rg.Members, fmt.Sprintf("group %d for the %s moved from %s to %s",
+		rg.Number, rg.raid.String(), was.Format("3:04 PM"), t.Format("3:04 PM")))
+}
+
+// move, cancel or note group n of a raid for a user, replying in channelID;
+// only the group's creator or a moderator can
+func (bs *BotState) changeGroup(channelID, userID string, mod bool, raid *Raid, n int, verb, text string) {
+	bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+		rg := raid.group(n)
+		if rg == nil || rg.Expired {
+			out.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> the %s has no group %d",
+				userID, raid.String(), n))
+			return
+		}
+		if rg.Creator != userID && !mod {
+			out.ChannelMessageSend(channelID, fmt.Sprintf("<@%s> only whoever added group %d or a moderator can change it",
+				userID, n))
+			return
+		}
+
+		switch verb {
+		case "move":
+			t, ok := bs.groupTime(out, raid, channelID, text)
+			if !ok {
+				return
+			}
+			bs.moveGroup(out, rg, t)
+			bs.scheduleRaid(raid)
+			bs.updatePost(out, raid)
+		case "cancel":
+			// tells the members
+			raid.RemoveGroup(rg, out, bs.notifier(out))
+			bs.updatePost(out, raid)
+			bs.scheduleRaid(raid)
+		case "note":
+			rg.Note = text
+			if text != "" {
+				bs.notify(out, raid.ChannelID, rg.Members, fmt.Sprintf("note for group %d for the %s: %s",
+					n, raid.String(), text))
+			}
+			bs.updatePost(out, raid)
+		}
+		log.Printf("%s %s group %d of %s", userID, verb, n, raid.String())
+		bs.recordRaid(raid)
+		out.ChannelMessageSend(channelID, "<@"+userID+"> Got it!")
+	})
+}
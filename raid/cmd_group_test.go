@@ -0,0 +1,84 @@
+package raid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBotState_ChangeGroup(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := newTestRaid(t, bs, "raid")
+	for _, mins := range []int{40, 50} {
+		rg := r.AddGroup(clock.Now().Add(time.Duration(mins)*time.Minute), s)
+		rg.Creator = "alice"
+		rg.Members["bob"] = 1
+	}
+	bs.scheduleRaid(r)
+
+	raid, n, rest, err := bs.findGroup(strings.Fields("val vista 2 4:30pm"))
+	if err != nil || raid != r || n != 2 || strings.Join(rest, " ") != "4:30pm" {
+		t.Fatal("wrong group", n, rest, err)
+	}
+	if _, _, _, err := bs.findGroup(strings.Fields("nowhere 2")); err == nil {
+		t.Fatal("found a raid that isn't there")
+	}
+
+	bs.changeGroup("cmds", "bob", false, r, 2, "note", "by the fountain")
+	if r.group(2).Note != "" {
+		t.Fatal("bob can't change alice's group")
+	}
+	bs.changeGroup("cmds", "carol", true, r, 2, "note", "by the fountain")
+	bs.changeGroup("cmds", "alice", false, r, 2, "move", "4:02pm")
+	bs.changeGroup("cmds", "alice", false, r, 1, "cancel", "")
+	bs.outbox.Flush()
+	t.Log(s.sent)
+	if r.group(1) != nil || r.Groups[0].Note != "by the fountain" || len(s.sentWith("only whoever added")) != 1 ||
+		len(s.sentWith("<@bob> note for group 2")) != 1 || len(s.sentWith("<@bob> group 2 for the ho-oh raid")) != 1 ||
+		len(s.sentWith("was cancelled")) != 1 {
+		t.Fatal("group not changed")
+	}
+
+	// the moved group starts at its new time
+	clock.Advance(36 * time.Minute)
+	bs.sched.RunDue(clock.Now())
+	if !r.Groups[0].Expired {
+		t.Fatal("moved group didn't start")
+	}
+}
+
+// editing a request's start time moves group 1 the way !group move does
+func TestBotState_EditRequestStart(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := newTestRaid(t, bs, "raid")
+	rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	rg.Members["bob"] = 1
+	rg.Reminded = []int{10}
+	edit := func(content string) {
+		r.mut.Lock()
+		out := &deferred{}
+		if err := bs.applyRequest(out, r, content, clock.Now()); err != nil {
+			t.Fatal(err)
+		}
+		r.mut.Unlock()
+		out.flush(bs.outbox)
+		bs.outbox.Flush()
+	}
+
+	edit("!raid ho-oh denker hatches in 30m starts in 50m")
+	if !rg.StartTime.Equal(clock.Now().Add(50*time.Minute)) || rg.Reminded != nil ||
+		len(s.sentWith("<@bob> group 1 for the ho-oh raid")) != 1 {
+		t.Fatal("group not moved", rg.StartTime, s.sent)
+	}
+
+	// a group that's started stays put
+	rg.Expired = true
+	edit("!raid ho-oh denker hatches in 30m starts in 45m")
+	if !rg.StartTime.Equal(clock.Now().Add(50*time.Minute)) || len(s.sentWith("moved from")) != 1 {
+		t.Fatal("started group moved", rg.StartTime)
+	}
+}
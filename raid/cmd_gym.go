@@ -3,17 +3,43 @@ package raid
 import (
 	"github.com/bwmarrin/discordgo"
 	"strings"
+	"raidquaza/gymdb"
 	"raidquaza/util"
 	"log"
 	"fmt"
+	"bytes"
+	"strconv"
 )
 
+const maxDupesShown = 10 // duplicate clusters listed by !gym dupes
+
+// look up a single gym, disabled or not; if there isn't exactly one match,
+// tell the user and return nil
+func (bs *BotState) findOneGym(s *discordgo.Session, m *discordgo.MessageCreate, query string) *gymdb.Gym {
+	gs, _ := bs.gymdb.SearchGyms(query, 1.0, true)
+	if len(gs) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't find a matching gym")
+		return nil
+	}
+	if len(gs) != 1 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Which gym did you mean?\n%s",
+			m.Author.ID, strings.Join(formatGymMatches(gs, nil), "\n")))
+		return nil
+	}
+	return gs[0]
+}
+
 func (bs *BotState) gymCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
 	// usage:
 	//  - !gym new <lat,lon> Gym Name
 	//  - !gym edit <query> name New Name
 	//  - !gym edit <query> location lat,lon
-	//  - !gym remove <query>
+	//  - !gym edit <query> set <key> [value]
+	//  - !gym remove [--hard] <query>
+	//  - !gym enable|disable <query>
+	//  - !gym export <csv|geojson|kml|gpx>
+	//  - !gym dupes [meters]
+	//  - !gym merge <keep id> <drop id>
 	//  - !gym undo (?)
 	tokens := strings.Split(query, " ")
 	switch tokens[0] {
@@ -39,27 +65,44 @@ func (bs *BotState) gymCommand(s *discordgo.Session, m *discordgo.MessageCreate,
 		addGymEmbed(gym, &messageData)
 		s.ChannelMessageSendComplex(m.ChannelID, &messageData)
 	case "remove":
-		gs, _ := bs.gymdb.GetGyms(query, 1.0)
-		if len(gs) == 0 {
-			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't find a matching gym")
+		// soft delete unless asked otherwise
+		hard := len(tokens) > 1 && tokens[1] == "--hard"
+		gymquery := tokens[1:]
+		if hard {
+			gymquery = tokens[2:]
+		}
+		gym := bs.findOneGym(s, m, strings.Join(gymquery, " "))
+		if gym == nil {
 			return
 		}
-		if len(gs) != 1 {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Which gym did you mean?\n%s",
-				m.Author.ID, strings.Join(formatGymMatches(gs, nil), "\n")))
+		err := bs.gymdb.RemoveGym(gym, !hard)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> error: "+err.Error())
+			return
+		}
+		if hard {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> gym deleted: "+gym.String())
+		} else {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> gym disabled: "+gym.String()+
+				"\nUse `!gym enable` to undo or `!gym remove --hard` to delete it for good.")
+		}
+	case "enable", "disable":
+		gym := bs.findOneGym(s, m, strings.Join(tokens[1:], " "))
+		if gym == nil {
 			return
 		}
-		err := bs.gymdb.RemoveGym(gs[0])
+		err := bs.gymdb.SetEnabled(gym, tokens[0] == "enable")
 		if err != nil {
 			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> error: "+err.Error())
 			return
 		}
-		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> gym deleted: "+gs[0].String())
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> gym "+tokens[0]+"d: "+gym.String())
 	case "edit":
 		q := strings.Split(query, " ")
 		var gymquery []string
 		var newname []string
 		var newloc []string
+		var newmeta []string
 		for i := len(q) - 1; i >= 0; i-- {
 			if q[i] == "name" {
 				gymquery = q[:i]
@@ -67,33 +110,33 @@ func (bs *BotState) gymCommand(s *discordgo.Session, m *discordgo.MessageCreate,
 			} else if q[i] == "location" {
 				gymquery = q[:i]
 				newloc = q[i+1:]
+			} else if q[i] == "set" && i+1 < len(q) {
+				// everything after set is the key and value
+				gymquery = q[:i]
+				newmeta = q[i+1:]
+				newname, newloc = nil, nil
 			}
 		}
 		if gymquery == nil {
 			s.ChannelMessageSend(m.ChannelID, "<@" + m.Author.ID+
 				"> use `!gym edit <gym name/id> name <new name>`\n"+
-				" or `!gym edit <gym name/id> location <lat,lon>")
-			return
-		}
-		gs, _ := bs.gymdb.GetGyms(strings.Join(gymquery, " "), 1.0)
-		if len(gs) == 0 {
-			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't find a matching gym")
+				" or `!gym edit <gym name/id> location <lat,lon>`\n"+
+				" or `!gym edit <gym name/id> set <ex|tags|notes|key> [value]`")
 			return
 		}
-		if len(gs) != 1 {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Which gym did you mean?\n%s",
-				m.Author.ID, strings.Join(formatGymMatches(gs, nil), "\n")))
+		gym := bs.findOneGym(s, m, strings.Join(gymquery, " "))
+		if gym == nil {
 			return
 		}
 		if newname != nil {
-			oldName := gs[0].Name
-			err := bs.gymdb.RenameGym(gs[0], strings.Join(newname, " "))
+			oldName := gym.Name
+			err := bs.gymdb.RenameGym(gym, strings.Join(newname, " "))
 			if err != nil {
 				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
 				return
 			}
 			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Renamed `%s` to `%s`!",
-				m.Author.ID, oldName, gs[0].Name))
+				m.Author.ID, oldName, gym.Name))
 		}
 		if newloc != nil {
 			lat, lon, _, err := util.ParseLatLong(newloc)
@@ -101,16 +144,94 @@ func (bs *BotState) gymCommand(s *discordgo.Session, m *discordgo.MessageCreate,
 				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't understand the new location")
 				return
 			}
-			oldLoc := fmt.Sprintf("%f,%f (%s)", gs[0].Latitude, gs[0].Longitude, gs[0].StreetAddr)
-			err = bs.gymdb.MoveGym(gs[0], lat, lon)
+			oldLoc := fmt.Sprintf("%f,%f (%s)", gym.Latitude, gym.Longitude, gym.StreetAddr)
+			err = bs.gymdb.MoveGym(gym, lat, lon)
 			if err != nil {
 				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
 				return
 			}
-			newLoc := fmt.Sprintf("%f,%f (%s)", gs[0].Latitude, gs[0].Longitude, gs[0].StreetAddr)
+			newLoc := fmt.Sprintf("%f,%f (%s)", gym.Latitude, gym.Longitude, gym.StreetAddr)
 			s.ChannelMessageSend(m.ChannelID, "<@" + m.Author.ID + "> Moved "+
-				gs[0].Name+ " from "+ oldLoc+ " to "+ newLoc)
+				gym.Name+ " from "+ oldLoc+ " to "+ newLoc)
+		}
+		if newmeta != nil {
+			key, value := newmeta[0], strings.Join(newmeta[1:], " ")
+			err := bs.gymdb.SetMetadata(gym, key, value)
+			if err != nil {
+				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
+				return
+			}
+			messageData := discordgo.MessageSend{}
+			messageData.Content = fmt.Sprintf("<@%s> Updated `%s` on %s", m.Author.ID, key, gym.Name)
+			addGymEmbed(gym, &messageData)
+			s.ChannelMessageSendComplex(m.ChannelID, &messageData)
+		}
+	case "export":
+		format := gymdb.FormatGeoJSON
+		if len(tokens) > 1 {
+			format = strings.ToLower(tokens[1])
+		}
+		buf := &bytes.Buffer{}
+		err := bs.gymdb.Export(buf, format)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error()+
+				"; use `!gym export <csv|geojson|kml|gpx>`")
+			return
+		}
+		_, err = s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("<@%s> %d gyms:", m.Author.ID, len(bs.gymdb.Gyms)),
+			Files: []*discordgo.File{{
+				Name:   "gyms." + format,
+				Reader: buf,
+			}},
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	case "dupes":
+		maxDist := gymdb.DefaultImportOptions.DuplicateDistance
+		if len(tokens) > 1 {
+			d, err := strconv.ParseFloat(tokens[1], 64)
+			if err != nil {
+				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!gym dupes [meters]`")
+				return
+			}
+			maxDist = d
+		}
+		clusters := bs.gymdb.FindDuplicates(maxDist, gymdb.DefaultImportOptions.DuplicateSimilarity)
+		if len(clusters) == 0 {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> no duplicate gyms within %0.0fm",
+				m.Author.ID, maxDist))
+			return
+		}
+		lines := []string{fmt.Sprintf("<@%s> %d possible duplicates within %0.0fm; use `!gym merge <keep id> <drop id>`:",
+			m.Author.ID, len(clusters), maxDist)}
+		for i, c := range clusters {
+			if i == maxDupesShown {
+				lines = append(lines, fmt.Sprintf("...and %d more", len(clusters)-i))
+				break
+			}
+			lines = append(lines, strings.Join(formatGymMatches(c, nil), "\n"))
+		}
+		s.ChannelMessageSend(m.ChannelID, strings.Join(lines, "\n"))
+	case "merge":
+		if len(tokens) != 3 {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!gym merge <keep id> <drop id>`")
+			return
+		}
+		keep, drop := bs.gymdb.GetGymById(tokens[1]), bs.gymdb.GetGymById(tokens[2])
+		if keep == nil || drop == nil {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't find those gym ids")
+			return
+		}
+		err := bs.gymdb.MergeGyms(keep, drop)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
+			return
 		}
+		n := bs.repointRaids(drop, keep)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> merged `%s` into %s (%d active raids moved)",
+			m.Author.ID, drop.Name, keep.String(), n))
 	case "save": // undocumented
 		log.Print("Resaving gymdb")
 		err := bs.gymdb.UpdateDiskDB()
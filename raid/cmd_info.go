@@ -11,21 +11,51 @@ import (
 func formatGymMatches(gs []*gymdb.Gym, scores []float32) []string {
 	var matches []string
 	for i, g := range gs {
+		disabled := ""
+		if !g.Enabled {
+			disabled = " (disabled)"
+		}
 		if scores == nil {
 			matches = append(matches, fmt.Sprintf(
-				"  [gym `%s`] %s %s <https://www.google.com/maps/?q=%f,%f>",
-				g.Id, g.Name, g.StreetAddr, g.Latitude, g.Longitude))
+				"  [gym `%s`] %s %s <https://www.google.com/maps/?q=%f,%f>%s",
+				g.Id, g.Name, g.StreetAddr, g.Latitude, g.Longitude, disabled))
 		} else {
 			matches = append(matches, fmt.Sprintf(
-				"  %0.1f%% [gym `%s`] %s %s <https://www.google.com/maps/?q=%f,%f>",
-				scores[i]*100.0, g.Id, g.Name, g.StreetAddr, g.Latitude, g.Longitude))
+				"  %0.1f%% [gym `%s`] %s %s <https://www.google.com/maps/?q=%f,%f>%s",
+				scores[i]*100.0, g.Id, g.Name, g.StreetAddr, g.Latitude, g.Longitude, disabled))
 		}
 	}
 	return matches
 }
 
+// flag to include disabled gyms in gym searches
+const allGymsFlag = "--all"
+
+const maxFilterResults = 10 // gyms listed by !info with only filter terms
+
 func (bs *BotState) infoCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
-	gs, scores := bs.gymdb.GetGyms(query, 0.5)
+	includeDisabled := strings.HasPrefix(query, allGymsFlag+" ")
+	if includeDisabled {
+		query = strings.TrimSpace(query[len(allGymsFlag):])
+	}
+	query, filter := gymdb.ParseGymFilter(query)
+	var gs []*gymdb.Gym
+	var scores []float32
+	if query == "" && !filter.Empty() {
+		// only filters; list everything that matches
+		gs = bs.gymdb.FilterGyms(filter, includeDisabled)
+		if len(gs) > maxFilterResults {
+			gs = gs[:maxFilterResults]
+		}
+	} else {
+		matches, matchScores := bs.gymdb.SearchGyms(query, 0.5, includeDisabled)
+		for i, g := range matches {
+			if filter.Match(g) {
+				gs = append(gs, g)
+				scores = append(scores, matchScores[i])
+			}
+		}
+	}
 	if len(gs) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> couldn't find a matching gym")
 		return
@@ -37,6 +67,9 @@ func (bs *BotState) infoCommand(s *discordgo.Session, m *discordgo.MessageCreate
 		g := gs[0]
 		messageData.Content = fmt.Sprintf("<@%s> [gym `%s`] %s | %s",
 			m.Author.ID, g.Id, g.Name, g.StreetAddr)
+		if !g.Enabled {
+			messageData.Content += " (disabled)"
+		}
 		addGymEmbed(g, &messageData)
 	} else {
 		matches := []string{fmt.Sprintf("<@%s> `%s` could be:", m.Author.ID, query)}
@@ -2,7 +2,6 @@ package raid
 
 import (
 	"github.com/bwmarrin/discordgo"
-	"time"
 	"fmt"
 	"strings"
 	"log"
@@ -16,7 +15,7 @@ func (bs *BotState) raidCommand(s *discordgo.Session, m *discordgo.MessageCreate
 		RequestMsgID: m.ID,
 		ChannelID: m.ChannelID,
 	}
-	err, gymmatches := r.ParseRaidRequest(query, bs.gymdb, time.Now())
+	err, gymmatches := r.ParseRaidRequest(query, bs.gymdb, bs.clock.Now())
 	if err == ErrNoEnd {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> You need to tell me an end time. Use `!raid <pokemon> @ <location> [hatches/ends] [at/in] <time>`",
 			m.Author.ID))
@@ -24,6 +23,10 @@ func (bs *BotState) raidCommand(s *discordgo.Session, m *discordgo.MessageCreate
 	} else if err == ErrNoMatches {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Couldn't find the gym you're looking for", m.Author.ID))
 		return
+	} else if err == ErrDisabled {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> %s is disabled and can't host raids",
+			m.Author.ID, gymmatches[0].Name))
+		return
 	} else if err == ErrNonUnique {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Which gym did you mean?\n%s",
 			m.Author.ID, strings.Join(formatGymMatches(gymmatches, nil), "\n")))
@@ -35,8 +38,49 @@ func (bs *BotState) raidCommand(s *discordgo.Session, m *discordgo.MessageCreate
 		return
 	}
 
+	bs.postRaid(s, m, r)
+}
+
+func (bs *BotState) exraidCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// !exraid denker jun 18 4:00pm
+	// !exraid denker sat 1:00 pm
+	r := &Raid{
+		RequestMsgID: m.ID,
+		ChannelID:    m.ChannelID,
+	}
+	err, gymmatches := r.ParseExRaidRequest(query, bs.gymdb, bs.clock.Now())
+	if err == ErrNoEnd {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> You need to tell me the date and time. Use `!exraid <gym name> <date> <time>`",
+			m.Author.ID))
+		return
+	} else if err == ErrPast {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> That EX raid is in the past!", m.Author.ID))
+		return
+	} else if err == ErrNoMatches {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Couldn't find the gym you're looking for", m.Author.ID))
+		return
+	} else if err == ErrDisabled {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> %s is disabled and can't host raids",
+			m.Author.ID, gymmatches[0].Name))
+		return
+	} else if err == ErrNonUnique {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Which gym did you mean?\n%s",
+			m.Author.ID, strings.Join(formatGymMatches(gymmatches, nil), "\n")))
+		return
+	} else if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Didn't understand: %s. Use `!exraid <gym name> <date> <time>`",
+			m.Author.ID, err))
+		return
+	}
+
+	bs.postRaid(s, m, r)
+}
+
+// post and pin a newly parsed raid, and start tracking it
+func (bs *BotState) postRaid(s *discordgo.Session, m *discordgo.MessageCreate, r *Raid) {
+	r.GuildID = channelGuild(s, m.ChannelID)
 	messageData := discordgo.MessageSend{
-		Content: r.GenMessage(),
+		Content: r.GenMessage(bs.limits(r.GuildID)),
 	}
 	addGymEmbed(r.Gym, &messageData)
 
@@ -46,6 +90,10 @@ func (bs *BotState) raidCommand(s *discordgo.Session, m *discordgo.MessageCreate
 		return
 	}
 	r.MessageID = msgId.ID
+	r.Request = m.Content
+	if rg := r.group(1); rg != nil {
+		rg.Creator = m.Author.ID
+	}
 
 	s.ChannelMessagePin(m.ChannelID, msgId.ID)
 
@@ -67,19 +115,21 @@ func (bs *BotState) raidCommand(s *discordgo.Session, m *discordgo.MessageCreate
 	}
 
 	if len(r.Groups) > 0 {
-		s.MessageReactionAdd(m.ChannelID, msgId.ID, "➕")
-		s.MessageReactionAdd(m.ChannelID, msgId.ID, "➖")
+		r.addGroupReactions(s)
 
-		for n := range r.Groups {
-			s.MessageReactionAdd(m.ChannelID, msgId.ID,
-				fmt.Sprintf("%d%s", n+1, boxEmoji))
+		for _, rg := range r.Groups {
+			s.MessageReactionAdd(m.ChannelID, msgId.ID, groupEmoji(rg.Number))
 		}
 	}
 
 	bs.mut.Lock()
 	bs.activeMessages[m.ID] = &Request{r}
 	bs.Raids[msgId.ID] = r
-	bs.dirty = true
 	bs.mut.Unlock()
+	r.mut.Lock()
+	r.skipDueReminders(bs.clock.Now())
+	bs.scheduleRaid(r)
+	bs.recordRaid(r)
+	r.mut.Unlock()
 }
 
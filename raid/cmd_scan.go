@@ -13,6 +13,7 @@ func (bs *BotState) scanCommand(s *discordgo.Session, m *discordgo.MessageCreate
 	lat, lon, _, err := util.ParseLatLong(strings.Split(query, " "))
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> can't parse your lat/lon; example: -37.123,121.85")
+		return
 	}
 
 	gyms, err := gymdb.ScrapeGymhuntr(lat, lon)
@@ -23,8 +24,27 @@ func (bs *BotState) scanCommand(s *discordgo.Session, m *discordgo.MessageCreate
 		gyms = gyms[:10]
 	}
 
+	// reconcile against the gym DB
+	status := make(map[*gymdb.Gym]string)
+	diff := bs.gymdb.DiffImport(gyms, gymdb.DefaultImportOptions)
+	for _, g := range diff.New {
+		status[g] = "**new**"
+	}
+	for _, c := range append(diff.Moved, diff.Renamed...) {
+		status[c.Imported] = "changed"
+	}
+	for _, c := range diff.Duplicates {
+		status[c.Imported] = fmt.Sprintf("dupe of `%s`?", c.Existing.Id)
+	}
+
 	matches := []string{fmt.Sprintf("<@%s> gyms around %f,%f:", m.Author.ID, lat, lon)}
-	matches = append(matches, formatGymMatches(gyms, nil)...)
+	for i, match := range formatGymMatches(gyms, nil) {
+		st, ok := status[gyms[i]]
+		if !ok {
+			st = "known"
+		}
+		matches = append(matches, st+match)
+	}
 
 	_, err = s.ChannelMessageSend(m.ChannelID, strings.Join(matches, "\n"))
 	if err != nil {
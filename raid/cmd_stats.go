@@ -0,0 +1,188 @@
+package raid
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const statsTopN = 5 // entries in each leaderboard
+
+// stats periods, for !stats top
+var statsPeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// tally counts things by name
+type tally map[string]int
+
+// the n biggest counts, most first, as "name (count)"
+func (t tally) top(n int, format func(string) string) string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if t[keys[i]] != t[keys[j]] {
+			return t[keys[i]] > t[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	if len(keys) == 0 {
+		return "nobody yet"
+	}
+	var entries []string
+	for _, k := range keys {
+		entries = append(entries, fmt.Sprintf("%s (%d)", format(k), t[k]))
+	}
+	return strings.Join(entries, ", ")
+}
+
+func plain(s string) string   { return s }
+func mention(s string) string { return "<@" + s + ">" }
+
+// a user's raids
+func userStats(records []*HistoryRecord, userID string) string {
+	raids, accounts := 0, 0
+	bosses, gyms := tally{}, tally{}
+	var last *HistoryRecord
+	for _, r := range records {
+		if !r.Attended(userID) {
+			continue
+		}
+		raids++
+		most := 0
+		for _, g := range r.Groups {
+			if g.Members[userID] > most {
+				most = g.Members[userID]
+			}
+		}
+		accounts += most
+		bosses[r.Boss]++
+		gyms[r.GymName]++
+		last = r
+	}
+	if raids == 0 {
+		return "No raids yet. Join a group on a raid post to get started!"
+	}
+	return fmt.Sprintf("Raids: %d (%d accounts counting extras)\nTop bosses: %s\nTop gyms: %s\nLast raid: %s at %s, %s",
+		raids, accounts, bosses.top(statsTopN, plain), gyms.top(statsTopN, plain),
+		last.Boss, last.GymName, last.EndTime.Format("Jan 2"))
+}
+
+// the raids at a gym
+func gymStats(records []*HistoryRecord, gymID string) string {
+	raids, attendance := 0, 0
+	bosses, raiders := tally{}, tally{}
+	for _, r := range records {
+		if r.GymID != gymID {
+			continue
+		}
+		raids++
+		bosses[r.Boss]++
+		for id := range r.Raiders() {
+			raiders[id]++
+			attendance++
+		}
+	}
+	if raids == 0 {
+		return "No raids here yet."
+	}
+	return fmt.Sprintf("Raids: %d with %d raiders (%d different)\nTop bosses: %s\nRegulars: %s",
+		raids, attendance, len(raiders), bosses.top(statsTopN, plain), raiders.top(statsTopN, mention))
+}
+
+// leaderboards of raiders, gyms and bosses
+func topStats(records []*HistoryRecord) string {
+	raiders, gyms, bosses := tally{}, tally{}, tally{}
+	for _, r := range records {
+		gyms[r.GymName]++
+		bosses[r.Boss]++
+		for id := range r.Raiders() {
+			raiders[id]++
+		}
+	}
+	return fmt.Sprintf("Raids: %d\nMost raids: %s\nMost raided gyms: %s\nMost raided bosses: %s",
+		len(records), raiders.top(statsTopN, mention), gyms.top(statsTopN, plain), bosses.top(statsTopN, plain))
+}
+
+func (bs *BotState) statsCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !stats me
+	//  - !stats gym <query>
+	//  - !stats top [day|week|month|year|all]
+	//  - !stats boss [pokemon]
+	if bs.history == nil {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> sorry, raid history isn't available")
+		return
+	}
+	tokens := strings.Fields(query)
+	if len(tokens) == 0 {
+		tokens = []string{"me"}
+	}
+	// only this server's raids
+	guildID := channelGuild(s, m.ChannelID)
+	var title, desc string
+	switch tokens[0] {
+	case "me":
+		title = fmt.Sprintf("Raid stats for %s", m.Author.Username)
+		desc = userStats(bs.history.Since(guildID, time.Time{}), m.Author.ID)
+	case "gym":
+		if len(tokens) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!stats gym <gym name>`")
+			return
+		}
+		gym := bs.findOneGym(s, m, strings.Join(tokens[1:], " "))
+		if gym == nil {
+			return
+		}
+		title = fmt.Sprintf("Raid stats for %s", gym.Name)
+		desc = gymStats(bs.history.Since(guildID, time.Time{}), gym.Id)
+	case "top":
+		period := "all"
+		if len(tokens) > 1 {
+			period = strings.ToLower(tokens[1])
+		}
+		d, ok := statsPeriods[period]
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> period must be day, week, month, year or all")
+			return
+		}
+		since := time.Time{}
+		title = "Top raiders of all time"
+		if d != 0 {
+			since = bs.clock.Now().Add(-d)
+			title = "Top raiders this " + period
+		}
+		desc = topStats(bs.history.Since(guildID, since))
+	case "boss":
+		boss := strings.Join(tokens[1:], " ")
+		title = "Raid outcomes by boss"
+		if boss != "" {
+			title = "Raid outcomes against " + boss
+		}
+		desc = bossStats(bs.history.OutcomesSince(guildID, time.Time{}), boss)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!stats me`, `!stats gym <gym name>`, `!stats top [period]` or `!stats boss [pokemon]`")
+		return
+	}
+
+	// an embed, so the leaderboards don't ping everyone on them
+	_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Embed: &discordgo.MessageEmbed{Title: title, Description: desc},
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
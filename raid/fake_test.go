@@ -0,0 +1,121 @@
+package raid
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeSession records what would have been sent to discord
+type fakeSession struct {
+	mut      sync.Mutex
+	sent     []string // "<channel id>: <content>"
+	edits    []string // "<message id>: <content>"
+	removals int      // reactions removed
+	n        int
+	fail     int // fail this many calls with a 429
+
+	// what ChannelMessage and MessageReactions return, by message id and
+	// by message id + emoji
+	messages  map[string]*discordgo.Message
+	reactions map[string][]*discordgo.User
+	deleted   []string // deleted message ids
+}
+
+// returns a 429 while f.fail > 0; must hold f.mut
+func (f *fakeSession) err() error {
+	if f.fail > 0 {
+		f.fail--
+		return &discordgo.RESTError{Response: &http.Response{
+			Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}}
+	}
+	return nil
+}
+
+func (f *fakeSession) record(channelID, content string) (*discordgo.Message, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	f.n++
+	f.sent = append(f.sent, channelID+": "+content)
+	return &discordgo.Message{ID: fmt.Sprintf("msg%d", f.n), ChannelID: channelID, Content: content}, nil
+}
+
+// sent messages containing substr
+func (f *fakeSession) sentWith(substr string) []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	var msgs []string
+	for _, m := range f.sent {
+		if strings.Contains(m, substr) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func (f *fakeSession) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	return f.record(channelID, content)
+}
+
+func (f *fakeSession) ChannelMessageEdit(channelID, messageID, content string) (*discordgo.Message, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	f.edits = append(f.edits, messageID+": "+content)
+	return &discordgo.Message{ID: messageID, ChannelID: channelID, Content: content}, nil
+}
+
+func (f *fakeSession) ChannelMessageUnpin(channelID, messageID string) error {
+	return nil
+}
+
+func (f *fakeSession) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	return nil
+}
+
+func (f *fakeSession) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.removals++
+	return nil
+}
+
+func (f *fakeSession) MessageReactionsRemoveAll(channelID, messageID string) error {
+	return nil
+}
+
+func (f *fakeSession) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + recipientID}, nil
+}
+
+func (f *fakeSession) ChannelMessage(channelID, messageID string) (*discordgo.Message, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if m, ok := f.messages[messageID]; ok {
+		return m, nil
+	}
+	return nil, &discordgo.RESTError{Response: &http.Response{
+		Status: "404 Not Found", StatusCode: http.StatusNotFound}}
+}
+
+func (f *fakeSession) ChannelMessageDelete(channelID, messageID string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.deleted = append(f.deleted, messageID)
+	delete(f.messages, messageID)
+	return nil
+}
+
+func (f *fakeSession) MessageReactions(channelID, messageID, emojiID string, limit int) ([]*discordgo.User, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.reactions[messageID+emojiID], nil
+}
@@ -5,21 +5,31 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"github.com/bwmarrin/discordgo"
 	"log"
+	"strconv"
 )
 
 type Group struct {
 	raid      *Raid
-	number    int
-	StartTime time.Time      `json:"start_time"`
-	Members   map[string]int `json:"members"` // discord userid set
-	Expired   bool           `json:"expired"`
+	Number    int                 `json:"number"` // kept when other groups are removed or moved
+	StartTime time.Time           `json:"start_time"`
+	Members   map[string]int      `json:"members"`              // discord userid set
+	Teams     map[string]string   `json:"teams,omitempty"`      // member's team, if known
+	Extras    map[string][]string `json:"extras,omitempty"`     // teams of a member's +N extras, if any are known
+	Modes     map[string]string   `json:"modes,omitempty"`      // how members are joining, if not in person
+	Cap       int                 `json:"cap,omitempty"`        // accounts the group takes, 0 for the guild's capacity
+	RemoteCap *int                `json:"remote_cap,omitempty"` // remote accounts the group takes, nil for the guild's
+	Waitlist  []*Waiter           `json:"waitlist,omitempty"`   // accounts waiting for spots, first come first served
+	Expired   bool                `json:"expired"`
+	Reminded  []int               `json:"reminded,omitempty"`   // lead times (minutes) of reminders already sent
+	Invited   map[string][]string `json:"invites,omitempty"`    // userid -> hosts or remote players they've been sent
+	Creator   string              `json:"creator,omitempty"`    // userid of whoever added the group
+	Note      string              `json:"note,omitempty"`       // set with !group note
 }
 
 func (rg *Group) String() string {
 	return fmt.Sprintf("%s%s raid at %s starting %s, ends %s", rg.raid.Emoji, rg.raid.What,
-		rg.raid.Gym.Name, rg.StartTime.Format("3:04 PM"),
+		rg.raid.Gym.Name, rg.StartTime.Format(rg.raid.timeFormat()),
 		rg.raid.EndTime.Format("3:04 PM"))
 }
 
@@ -31,50 +41,104 @@ func (rg *Group) Total() int {
 	return total
 }
 
-func (rg *Group) genMessage(n int) string {
+func (rg *Group) genMessage(l Limits) string {
 	startTime := rg.StartTime.Format("3:04 PM")
 	strikeThru := ""
 	if rg.Expired {
 		strikeThru = "~~"
 	}
-	return fmt.Sprintf("%d%s %s**%s** | %d attending: %s%s",
-		n, boxEmoji, strikeThru, startTime, rg.Total(), rg.Mentions(), strikeThru)
+	teams := rg.teamSummary()
+	if teams != "" {
+		teams = " (" + teams + ")"
+	}
+	if modes := rg.modeSummary(l.RemoteCap); modes != "" {
+		teams += " " + modes
+	}
+	attending := strconv.Itoa(rg.Total())
+	if rg.full(l.GroupCap) || rg.Cap > 0 {
+		attending += "/" + strconv.Itoa(rg.capacity(l.GroupCap))
+	}
+	details := ""
+	if rg.Note != "" {
+		details = "\n        📝 " + rg.Note
+	}
+	if len(rg.Waitlist) > 0 {
+		details += "\n        waitlist: " + rg.waitlistMentions()
+	}
+	return fmt.Sprintf("%s %s**%s** | %s attending%s: %s%s%s",
+		groupEmoji(rg.Number), strikeThru, startTime, attending, teams, rg.Mentions(), strikeThru, details)
 }
 
 func (rg *Group) Mentions() string {
 	var mentions []string
 	for mem, n := range rg.Members {
+		mention := "<@" + mem + ">"
+		if mode := rg.Modes[mem]; mode != ModeInPerson {
+			mention += modeEmoji[mode]
+		}
 		if n > 1 {
-			mentions = append(mentions, fmt.Sprintf("<@%s> (x%d)", mem, n))
-		} else {
-			mentions = append(mentions, "<@"+mem+">")
+			mention += fmt.Sprintf(" (x%d)", n)
 		}
+		mentions = append(mentions, mention)
 	}
 	sort.Strings(mentions)
 	return strings.Join(mentions, " ")
 }
 
-func (rg *Group) Expire(s *discordgo.Session) {
+// marks reminders with the given lead times (minutes) that are due at t as
+// sent, returning whether any were newly due; several falling due at once,
+// e.g. after a restart, only warrant one reminder
+func (rg *Group) dueReminders(leads []int, t time.Time) bool {
+	if rg.Expired {
+		return false
+	}
+	due := false
+	for _, lead := range leads {
+		if t.Before(rg.StartTime.Add(-time.Duration(lead) * time.Minute)) {
+			continue
+		}
+		sent := false
+		for _, r := range rg.Reminded {
+			sent = sent || r == lead
+		}
+		if !sent {
+			rg.Reminded = append(rg.Reminded, lead)
+			due = true
+		}
+	}
+	return due
+}
+
+func (rg *Group) Expire(s Session, n Notifier) {
 	if rg.Expired {
 		return
 	}
 	rg.Expired = true
 	log.Printf("%s expired.", rg.String())
 	if len(rg.Members) > 0 {
-		s.ChannelMessageSend(rg.raid.ChannelID, fmt.Sprintf("%s %s raid at %s starting now!",
-			rg.Mentions(), rg.StartTime.Format("3:04PM"), rg.raid.Gym.Name))
-		emoji := fmt.Sprintf("%d%s", rg.number, boxEmoji)
-		s.MessageReactionRemove(rg.raid.ChannelID, rg.raid.MessageID, emoji, s.State.User.ID)
-		for userId := range rg.Members {
-			s.MessageReactionRemove(rg.raid.ChannelID, rg.raid.MessageID, emoji, userId)
-		}
+		n.Notify(rg.raid.ChannelID, rg.Members, fmt.Sprintf("%s raid at %s starting now!",
+			rg.StartTime.Format("3:04PM"), rg.raid.Gym.Name))
+	}
+	// even the bot's own, so the group's reaction is free for a new one
+	rg.clearReactions(s)
+}
+
+// take the group's reaction off the raid post, the bot's and everyone's who
+// joined with it
+func (rg *Group) clearReactions(s Session) {
+	emoji := groupEmoji(rg.Number)
+	s.MessageReactionRemove(rg.raid.ChannelID, rg.raid.MessageID, emoji, "@me")
+	for userId := range rg.Members {
+		s.MessageReactionRemove(rg.raid.ChannelID, rg.raid.MessageID, emoji, userId)
+	}
+	for _, w := range rg.Waitlist {
+		s.MessageReactionRemove(rg.raid.ChannelID, rg.raid.MessageID, emoji, w.UserID)
 	}
 }
 
-func (rg *Group) Cancel(s *discordgo.Session) {
+func (rg *Group) Cancel(n Notifier) {
 	log.Printf("%s deleted.", rg.String())
 	if len(rg.Members) > 0 {
-		s.ChannelMessageSend(rg.raid.ChannelID, fmt.Sprintf("%s %s was cancelled",
-			rg.Mentions(), rg.String()))
+		n.Notify(rg.raid.ChannelID, rg.Members, rg.String()+" was cancelled")
 	}
 }
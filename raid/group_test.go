@@ -0,0 +1,125 @@
+package raid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroup_dueReminders(t *testing.T) {
+	t0, _ := time.Parse(time.RFC3339, "2018-05-22T20:00:00-07:00")
+	rg := &Group{StartTime: t0}
+	leads := []int{10, 2}
+
+	if rg.dueReminders(leads, t0.Add(-11*time.Minute)) {
+		t.Fatal("reminder too early")
+	}
+	if !rg.dueReminders(leads, t0.Add(-10*time.Minute)) {
+		t.Fatal("10 minute reminder not due")
+	}
+	if rg.dueReminders(leads, t0.Add(-9*time.Minute)) {
+		t.Fatal("10 minute reminder sent twice")
+	}
+	// both due at once, e.g. after a restart, is one reminder
+	rg = &Group{StartTime: t0}
+	if !rg.dueReminders(leads, t0.Add(-time.Minute)) || rg.dueReminders(leads, t0) {
+		t.Fatal(rg.Reminded)
+	}
+	t.Log(rg.Reminded)
+}
+
+func TestGroup_Teams(t *testing.T) {
+	rg := &Group{Members: make(map[string]int)}
+	rg.join("alice", TeamMystic, DefaultGroupCap)
+	rg.join("bob", TeamNone, DefaultGroupCap)
+	if rg.teamSummary() != "💙1 ❔1" {
+		t.Fatal(rg.teamSummary())
+	}
+
+	rg.addExtra("alice", TeamNone, DefaultGroupCap)
+	rg.addExtra("alice", TeamValor, DefaultGroupCap)
+	rg.addExtra("bob", TeamInstinct, DefaultGroupCap)
+	if rg.addExtra("carol", TeamValor, DefaultGroupCap) || rg.removeExtra("carol") {
+		t.Fatal("extras for someone not in the group")
+	}
+	t.Log(rg.teamSummary())
+	if rg.Total() != 5 || rg.teamSummary() != "💙1 ❤️1 💛1 ❔2" {
+		t.Fatal(rg.Members, rg.Extras)
+	}
+
+	// ➖ drops the last extra added
+	rg.removeExtra("alice")
+	if rg.Members["alice"] != 2 || rg.Extras["alice"] != nil {
+		t.Fatal(rg.Members, rg.Extras)
+	}
+	rg.leave("bob")
+	if rg.Total() != 2 || rg.Extras["bob"] != nil {
+		t.Fatal(rg.Members, rg.Extras)
+	}
+
+	if team, ok := heartTeam("❤"); !ok || team != TeamValor {
+		t.Fatal("❤ without the variation selector")
+	}
+}
+
+func TestGroup_Modes(t *testing.T) {
+	rg := &Group{Members: map[string]int{"alice": 1, "bob": 2, "carol": 1}}
+	if rg.modeSummary(3) != "" {
+		t.Fatal("everyone's in person")
+	}
+	if err := rg.setMode("bob", ModeInvite, 3); err != nil {
+		t.Fatal(err)
+	}
+	rg.setMode("alice", ModeHost, 3)
+	if err := rg.setMode("carol", ModeRemote, 2); err != ErrRemoteFull {
+		t.Fatal("cap not enforced", err)
+	}
+	if rg.canAddAccount("bob", 2) || !rg.canAddAccount("bob", 3) || !rg.canAddAccount("alice", 0) {
+		t.Fatal("extras not checked against the cap")
+	}
+	t.Log(rg.modeSummary(3), rg.Mentions())
+	if rg.modeSummary(3) != "🏃2 🏠1 📡2/3 📨2" || rg.Mentions() != "<@alice>🏠 <@bob>📨 (x2) <@carol>" {
+		t.Fatal("wrong summary")
+	}
+	// the group's own cap goes over the guild's, even when it's none
+	none := 0
+	rg.RemoteCap = &none
+	if rg.canAddAccount("bob", 3) || rg.modeSummary(3) != "🏃2 🏠1 📡2/0 📨2" {
+		t.Fatal("group's cap not used")
+	}
+	rg.RemoteCap = nil
+	rg.leave("bob")
+	if rg.remoteAccounts() != 0 || rg.Modes["bob"] != "" {
+		t.Fatal(rg.Modes)
+	}
+}
+
+func TestGroup_Waitlist(t *testing.T) {
+	rg := &Group{Members: map[string]int{}, Cap: 3}
+	if !rg.join("alice", TeamMystic, DefaultGroupCap) || !rg.addExtra("alice", TeamValor, DefaultGroupCap) || !rg.join("bob", TeamNone, DefaultGroupCap) {
+		t.Fatal("didn't join")
+	}
+	if rg.join("carol", TeamInstinct, DefaultGroupCap) || !rg.addExtra("carol", TeamNone, DefaultGroupCap) || !rg.addExtra("alice", TeamNone, DefaultGroupCap) {
+		t.Fatal("full group joined")
+	}
+	t.Log(rg.waitlistMentions())
+	if rg.Total() != 3 || rg.waitlistMentions() != "<@carol> (x2) <@alice> (+1)" {
+		t.Fatal("wrong waitlist")
+	}
+	if !rg.removeExtra("carol") || rg.removeExtra("carol") {
+		t.Fatal("waiting extra not dropped")
+	}
+
+	// bob leaving lets carol in, then alice's extra once the cap is raised
+	rg.leave("bob")
+	if p := rg.promote(DefaultGroupCap); len(p) != 1 || p[0] != "carol" || rg.Teams["carol"] != TeamInstinct {
+		t.Fatal("carol not promoted", p)
+	}
+	rg.Cap = 4
+	if p := rg.promote(DefaultGroupCap); len(p) != 1 || p[0] != "alice" || rg.Members["alice"] != 3 || rg.Waitlist != nil {
+		t.Fatal("alice's extra not promoted", p, rg.Waitlist)
+	}
+	rg.leave("alice")
+	if rg.Total() != 1 || rg.full(DefaultGroupCap) {
+		t.Fatal(rg.Members)
+	}
+}
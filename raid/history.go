@@ -0,0 +1,194 @@
+package raid
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"raidquaza/store"
+)
+
+// the raid history lives next to the snapshot, with this appended to its name
+const historySuffix = ".history"
+
+// HistoryRecord is what's kept of a raid once it's over
+type HistoryRecord struct {
+	Raid      string          `json:"raid"`               // raid message id
+	GuildID   string          `json:"guild_id,omitempty"` // server it was posted in, for its stats
+	Kind      string          `json:"kind,omitempty"`
+	Boss      string          `json:"boss"`
+	GymID     string          `json:"gym_id"`
+	GymName   string          `json:"gym_name"`
+	HatchTime time.Time       `json:"hatch_time"`
+	EndTime   time.Time       `json:"end_time"`
+	Groups    []*HistoryGroup `json:"groups"`
+}
+
+type HistoryGroup struct {
+	StartTime time.Time      `json:"start_time"`
+	Members   map[string]int `json:"members"` // userid -> accounts, including +N extras
+}
+
+// whether a user was in any of the raid's groups
+func (h *HistoryRecord) Attended(userID string) bool {
+	for _, g := range h.Groups {
+		if _, ok := g.Members[userID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the users in any of the raid's groups
+func (h *HistoryRecord) Raiders() map[string]bool {
+	raiders := make(map[string]bool)
+	for _, g := range h.Groups {
+		for id := range g.Members {
+			raiders[id] = true
+		}
+	}
+	return raiders
+}
+
+// lines in the history are raids, or group outcomes reported after them
+type historyLine struct {
+	Outcome *OutcomeRecord `json:"outcome,omitempty"`
+}
+
+// History is an append-only archive of finished raids, all kept in memory
+// for stats
+type History struct {
+	mut      sync.Mutex
+	records  []*HistoryRecord
+	ids      map[string]bool
+	outcomes []*OutcomeRecord
+	journal  *store.Journal
+}
+
+// OpenHistory loads the history at path, creating it if needed
+func OpenHistory(path string) (*History, error) {
+	h := &History{ids: make(map[string]bool)}
+	err := store.ReadJournal(path, func(rec []byte) error {
+		var line historyLine
+		if err := json.Unmarshal(rec, &line); err != nil {
+			return err
+		}
+		if line.Outcome != nil {
+			h.outcomes = append(h.outcomes, line.Outcome)
+			return nil
+		}
+		r := &HistoryRecord{}
+		if err := json.Unmarshal(rec, r); err != nil {
+			return err
+		}
+		h.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.journal, err = store.OpenJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// must hold h.mut, or own h
+func (h *History) add(r *HistoryRecord) bool {
+	if h.ids[r.Raid] {
+		return false
+	}
+	h.ids[r.Raid] = true
+	h.records = append(h.records, r)
+	return true
+}
+
+// Add archives a raid; adding one already there does nothing, so a raid
+// ending again after a crash isn't counted twice
+func (h *History) Add(r *HistoryRecord) error {
+	m, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if !h.add(r) {
+		return nil
+	}
+	return h.journal.Append(m)
+}
+
+// whether a record from guild recordGuild counts towards guildID's stats;
+// those archived before records had a guild count for every guild
+func inGuild(recordGuild, guildID string) bool {
+	return recordGuild == "" || recordGuild == guildID
+}
+
+// Since returns a guild's raids that ended at or after t, oldest first
+func (h *History) Since(guildID string, t time.Time) []*HistoryRecord {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	var records []*HistoryRecord
+	for _, r := range h.records {
+		if inGuild(r.GuildID, guildID) && !r.EndTime.Before(t) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// AddOutcome archives the reported outcome of a group
+func (h *History) AddOutcome(o *OutcomeRecord) error {
+	m, err := json.Marshal(historyLine{o})
+	if err != nil {
+		return err
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.outcomes = append(h.outcomes, o)
+	return h.journal.Append(m)
+}
+
+// OutcomesSince returns the outcomes of a guild's groups that started at or
+// after t
+func (h *History) OutcomesSince(guildID string, t time.Time) []*OutcomeRecord {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	var outcomes []*OutcomeRecord
+	for _, o := range h.outcomes {
+		if inGuild(o.GuildID, guildID) && !o.StartTime.Before(t) {
+			outcomes = append(outcomes, o)
+		}
+	}
+	return outcomes
+}
+
+func (h *History) Close() error {
+	return h.journal.Close()
+}
+
+// archive a finished raid; must hold raid.mut
+func (bs *BotState) archiveRaid(raid *Raid) {
+	if bs.history == nil {
+		return
+	}
+	r := &HistoryRecord{
+		Raid:      raid.MessageID,
+		GuildID:   raid.GuildID,
+		Kind:      raid.Kind,
+		Boss:      raid.What,
+		GymID:     raid.Gym.Id,
+		GymName:   raid.Gym.Name,
+		HatchTime: raid.HatchTime(),
+		EndTime:   raid.EndTime,
+	}
+	for _, rg := range raid.Groups {
+		g := &HistoryGroup{StartTime: rg.StartTime, Members: make(map[string]int)}
+		for id, n := range rg.Members {
+			g.Members[id] = n
+		}
+		r.Groups = append(r.Groups, g)
+	}
+	logErr(bs.history.Add(r))
+}
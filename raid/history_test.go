@@ -0,0 +1,125 @@
+package raid
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestHistory_ArchiveAndStats(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	for _, id := range []string{"raid1", "raid2"} {
+		r := newTestRaid(t, bs, id)
+		r.GuildID = "guild"
+		rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+		rg.Members["alice"] = 2
+		if id == "raid2" {
+			rg.Members["bob"] = 1
+		}
+		bs.scheduleRaid(r)
+	}
+	clock.Advance(2 * time.Hour)
+	bs.sched.RunDue(clock.Now())
+	if len(bs.Raids) != 0 || len(bs.history.Since("guild", time.Time{})) != 2 {
+		t.Fatal("raids not archived", bs.Raids)
+	}
+
+	// reopening keeps it, and archiving the same raid again doesn't count it
+	// twice
+	bs.history.Close()
+	h, err := OpenHistory(bs.snapshotPath + historySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.history = h
+	records := h.Since("guild", time.Time{})
+	if err := h.Add(records[0]); err != nil || len(h.Since("guild", time.Time{})) != 2 {
+		t.Fatal("duplicate archived", err)
+	}
+	if len(h.Since("guild", clock.Now())) != 0 {
+		t.Fatal("period not applied")
+	}
+	if len(h.Since("other", time.Time{})) != 0 {
+		t.Fatal("another guild's raids counted")
+	}
+
+	me := userStats(records, "alice")
+	t.Log(me)
+	if !strings.Contains(me, "Raids: 2 (4 accounts") || !strings.Contains(me, "ho-oh (2)") {
+		t.Fatal("wrong user stats")
+	}
+	gym := gymStats(records, records[0].GymID)
+	t.Log(gym)
+	if !strings.Contains(gym, "Raids: 2 with 3 raiders (2 different)") {
+		t.Fatal("wrong gym stats")
+	}
+	top := topStats(records)
+	t.Log(top)
+	if !strings.Contains(top, "Most raids: <@alice> (2), <@bob> (1)") {
+		t.Fatal("wrong leaderboard")
+	}
+}
+
+func TestHistory_Outcomes(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	r := newTestRaid(t, bs, "raid")
+	rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	rg.Members["alice"] = 2
+	rg.Members["bob"] = 1
+	bs.scheduleRaid(r)
+	clock.Advance(41 * time.Minute)
+	bs.sched.RunDue(clock.Now())
+	bs.outbox.Flush()
+	if len(bs.Outcomes) != 1 {
+		t.Fatal("no outcome report message", s.sent)
+	}
+	var id string
+	for id = range bs.Outcomes {
+	}
+	react := func(bs *BotState, user, emoji string, add bool) {
+		mr := &discordgo.MessageReaction{UserID: user, MessageID: id, ChannelID: "raids", Emoji: discordgo.Emoji{Name: emoji}}
+		if add {
+			bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: mr})
+		} else {
+			bs.messageReactionRemove(s, &discordgo.MessageReactionRemove{MessageReaction: mr})
+		}
+	}
+	react(bs, "alice", outcomeWonEmoji, true)
+	react(bs, "alice", "3"+boxEmoji, true)
+	react(bs, "bob", outcomeLostEmoji, true)
+	react(bs, "bob", outcomeLostEmoji, false)
+	react(bs, "carol", outcomeLostEmoji, true) // not in the group
+
+	// reports survive a crash
+	bs.journal.Close()
+	reloaded := newBotState(s, clock, bs.snapshotPath, "../gymdb/gyms.txt")
+	defer reloaded.Stop()
+	react(reloaded, "bob", outcomeShinyEmoji, true)
+	clock.Advance(outcomeWindow)
+	reloaded.sched.RunDue(clock.Now())
+	reloaded.outbox.Flush()
+	if len(reloaded.Outcomes) != 0 {
+		t.Fatal("outcome not closed")
+	}
+
+	outcomes := reloaded.history.OutcomesSince("", time.Time{})
+	if len(outcomes) != 1 || len(outcomes[0].Reports) != 2 {
+		t.Fatal("outcome not archived", outcomes)
+	}
+	summary := outcomes[0].summary()
+	t.Log(summary)
+	if summary != "Group 1 ho-oh raid at Val Vista Community Park: won, 3 accounts, 1 shiny ✨" {
+		t.Fatal("wrong summary")
+	}
+	boss := bossStats(outcomes, "Ho-Oh")
+	t.Log(boss)
+	if boss != "1 groups, 1 won, 0 lost, 3.0 accounts per group, 1 shinies from 2 raiders, 0 boosted" {
+		t.Fatal("wrong boss stats")
+	}
+}
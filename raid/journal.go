@@ -0,0 +1,230 @@
+package raid
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+)
+
+// the journal lives next to the snapshot, with this appended to its name
+const journalSuffix = ".journal"
+
+// one change to the state; replaying them in order on top of the last
+// snapshot recovers the state
+type journalRecord struct {
+	Version  int             `json:"version,omitempty"` // snapshot schema version it was written in; unset before v3
+	Raid     string          `json:"raid,omitempty"`    // raid message id
+	Data     json.RawMessage `json:"data,omitempty"`    // the raid's whole state, or nothing if it's gone
+	Settings *settingsRecord `json:"settings,omitempty"`
+	Prompts  *promptsRecord  `json:"prompts,omitempty"`
+	Outcome  *outcomeRecord  `json:"outcome,omitempty"`
+}
+
+type settingsRecord struct {
+	Users         map[string]*UserSettings `json:"users"`
+	ReminderLeads map[string][]int         `json:"reminder_leads,omitempty"`
+	RemoteCaps    map[string]int           `json:"remote_caps,omitempty"`
+	GroupCaps     map[string]int           `json:"group_caps,omitempty"`
+}
+
+// a user's pending prompts
+type promptsRecord struct {
+	User    string    `json:"user"`
+	Prompts []*Prompt `json:"prompts"`
+}
+
+// an outcome report message's state, or nothing if it's closed
+type outcomeRecord struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func (bs *BotState) appendRecord(r journalRecord) {
+	bs.markDirty()
+	if bs.journal == nil {
+		return
+	}
+	r.Version = snapshotSchema.Version
+	m, err := json.Marshal(r)
+	if err == nil {
+		err = bs.journal.Append(m)
+	}
+	if err != nil {
+		// the next snapshot will have it
+		log.Print(err)
+	}
+}
+
+// record a raid's current state; must hold raid.mut
+func (bs *BotState) recordRaid(raid *Raid) {
+	data, err := json.Marshal(raid)
+	if err != nil {
+		log.Print(err)
+		bs.markDirty()
+		return
+	}
+	bs.appendRecord(journalRecord{Raid: raid.MessageID, Data: data})
+}
+
+func (bs *BotState) recordRemoved(raidID string) {
+	bs.appendRecord(journalRecord{Raid: raidID})
+}
+
+// record the users' settings, reminder leads and caps; must hold bs.mut
+func (bs *BotState) recordSettings() {
+	bs.appendRecord(journalRecord{Settings: &settingsRecord{bs.Users, bs.ReminderLeads, bs.RemoteCaps, bs.GroupCaps}})
+}
+
+// record a user's pending prompts; must hold bs.mut
+func (bs *BotState) recordPrompts(userID string) {
+	bs.appendRecord(journalRecord{Prompts: &promptsRecord{userID, bs.Prompts[userID]}})
+}
+
+// where a record's data sits in the state: under the id at idKey in the map
+// at field, e.g. a raid record's data is the raid at raids[raid]
+var recordFields = []struct {
+	record, idKey, dataKey, field string // record is "" for the record itself
+}{
+	{"", "raid", "data", "raids"},
+	{"prompts", "user", "prompts", "prompts"},
+	{"outcome", "id", "data", "outcomes"},
+}
+
+// upgrade a record written by an older version, running the snapshot's
+// migrations over the part of the state it holds
+func upgradeRecord(rec []byte) ([]byte, error) {
+	var r map[string]json.RawMessage
+	if err := json.Unmarshal(rec, &r); err != nil {
+		return nil, err
+	}
+	version := 1 // from before records were versioned
+	if len(r["version"]) > 0 {
+		if err := json.Unmarshal(r["version"], &version); err != nil {
+			return nil, err
+		}
+	}
+	if version == snapshotSchema.Version {
+		return rec, nil
+	}
+
+	// the record as a state: settings are its top-level fields, and the
+	// rest go in their maps
+	state := make(map[string]json.RawMessage)
+	if len(r["settings"]) > 0 {
+		if err := json.Unmarshal(r["settings"], &state); err != nil {
+			return nil, err
+		}
+	}
+	parts := make([]map[string]json.RawMessage, len(recordFields))
+	ids := make([]string, len(recordFields))
+	for i, f := range recordFields {
+		var part map[string]json.RawMessage
+		if f.record == "" {
+			part = r
+		} else if len(r[f.record]) == 0 {
+			continue
+		} else if err := json.Unmarshal(r[f.record], &part); err != nil {
+			return nil, err
+		}
+		if len(part[f.dataKey]) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(part[f.idKey], &ids[i]); err != nil {
+			return nil, err
+		}
+		m, err := json.Marshal(map[string]json.RawMessage{ids[i]: part[f.dataKey]})
+		if err != nil {
+			return nil, err
+		}
+		state[f.field] = m
+		parts[i] = part
+	}
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		data, err = snapshotSchema.Upgrade(version, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state = make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	// and back
+	for i, f := range recordFields {
+		if parts[i] == nil {
+			continue
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(state[f.field], &m); err != nil {
+			return nil, err
+		}
+		delete(state, f.field)
+		parts[i][f.dataKey] = m[ids[i]]
+		if f.record != "" {
+			if r[f.record], err = json.Marshal(parts[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(r["settings"]) > 0 {
+		if r["settings"], err = json.Marshal(state); err != nil {
+			return nil, err
+		}
+	}
+	r["version"] = json.RawMessage(strconv.Itoa(snapshotSchema.Version))
+	return json.Marshal(r)
+}
+
+func (bs *BotState) applyRecord(rec []byte) error {
+	rec, err := upgradeRecord(rec)
+	if err != nil {
+		return err
+	}
+	var r journalRecord
+	if err := json.Unmarshal(rec, &r); err != nil {
+		return err
+	}
+	if r.Settings != nil {
+		bs.Users = r.Settings.Users
+		if bs.Users == nil {
+			bs.Users = make(map[string]*UserSettings)
+		}
+		bs.ReminderLeads = r.Settings.ReminderLeads
+		bs.RemoteCaps = r.Settings.RemoteCaps
+		bs.GroupCaps = r.Settings.GroupCaps
+	}
+	if r.Prompts != nil {
+		if len(r.Prompts.Prompts) == 0 {
+			delete(bs.Prompts, r.Prompts.User)
+		} else {
+			bs.Prompts[r.Prompts.User] = r.Prompts.Prompts
+		}
+	}
+	if r.Outcome != nil {
+		if r.Outcome.Data == nil {
+			delete(bs.Outcomes, r.Outcome.ID)
+		} else {
+			o := &OutcomeRecord{}
+			if err := json.Unmarshal(r.Outcome.Data, o); err != nil {
+				return err
+			}
+			bs.Outcomes[r.Outcome.ID] = o
+		}
+	}
+	if r.Raid == "" {
+		return nil
+	}
+	if r.Data == nil {
+		delete(bs.Raids, r.Raid)
+		return nil
+	}
+	raid := &Raid{}
+	if err := json.Unmarshal(r.Data, raid); err != nil {
+		return err
+	}
+	bs.Raids[r.Raid] = raid
+	return nil
+}
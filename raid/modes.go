@@ -0,0 +1,205 @@
+package raid
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how many remote accounts a group takes, unless its guild or the group set
+// another with !remotecap
+const DefaultRemoteCap = 10
+
+// how a member is joining a group
+const (
+	ModeInPerson = ""
+	ModeRemote   = "remote"
+	ModeInvite   = "invite" // remote, and needs someone to invite them
+	ModeHost     = "host"   // in person, and can invite remote players
+)
+
+// the order modes are shown in, with their reactions
+var modes = []string{ModeInPerson, ModeHost, ModeRemote, ModeInvite}
+
+var modeEmoji = map[string]string{
+	ModeInPerson: "🏃",
+	ModeHost:     "🏠",
+	ModeRemote:   "📡",
+	ModeInvite:   "📨",
+}
+
+var ErrRemoteFull = errors.New("too many remote players")
+
+// the mode a reaction picks, if it does
+func emojiMode(emoji string) (string, bool) {
+	for mode, e := range modeEmoji {
+		if emoji == e {
+			return mode, true
+		}
+	}
+	return ModeInPerson, false
+}
+
+func remoteMode(mode string) bool {
+	return mode == ModeRemote || mode == ModeInvite
+}
+
+// remote accounts in the group, extras included
+func (rg *Group) remoteAccounts() int {
+	n := 0
+	for userID, count := range rg.Members {
+		if remoteMode(rg.Modes[userID]) {
+			n += count
+		}
+	}
+	return n
+}
+
+// how many remote accounts the group takes: its own cap, or else its guild's
+func (rg *Group) remoteCapacity(guildCap int) int {
+	if rg.RemoteCap != nil {
+		return *rg.RemoteCap
+	}
+	return guildCap
+}
+
+// whether a member can bring one more account without going over the cap
+func (rg *Group) canAddAccount(userID string, guildCap int) bool {
+	return !remoteMode(rg.Modes[userID]) || rg.remoteAccounts() < rg.remoteCapacity(guildCap)
+}
+
+// change how a member is joining; going remote fails with ErrRemoteFull if
+// their accounts don't fit under the cap
+func (rg *Group) setMode(userID, mode string, guildCap int) error {
+	if _, ok := rg.Members[userID]; !ok {
+		return nil
+	}
+	if remoteMode(mode) && !remoteMode(rg.Modes[userID]) &&
+		rg.remoteAccounts()+rg.Members[userID] > rg.remoteCapacity(guildCap) {
+		return ErrRemoteFull
+	}
+	if mode == ModeInPerson {
+		delete(rg.Modes, userID)
+		return nil
+	}
+	if rg.Modes == nil {
+		rg.Modes = make(map[string]string)
+	}
+	rg.Modes[userID] = mode
+	return nil
+}
+
+// counts by mode like "🏃3 🏠1 📡2/10 📨1", or nothing if everyone's there in
+// person. Hosts are in person and those needing an invite are remote, so
+// they're counted twice.
+func (rg *Group) modeSummary(guildCap int) string {
+	if len(rg.Modes) == 0 {
+		return ""
+	}
+	counts := make(map[string]int)
+	for userID, count := range rg.Members {
+		mode := rg.Modes[userID]
+		counts[mode] += count
+		if mode == ModeHost {
+			counts[ModeInPerson] += count
+		} else if mode == ModeInvite {
+			counts[ModeRemote] += count
+		}
+	}
+	var parts []string
+	for _, mode := range modes {
+		if mode == ModeRemote {
+			parts = append(parts, fmt.Sprintf("%s%d/%d", modeEmoji[mode], counts[mode], rg.remoteCapacity(guildCap)))
+		} else if counts[mode] > 0 {
+			parts = append(parts, fmt.Sprintf("%s%d", modeEmoji[mode], counts[mode]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Limits are the settings a guild's groups are joined and shown under
+type Limits struct {
+	GroupCap  int // accounts a group takes, unless it has its own Cap
+	RemoteCap int // remote accounts a group takes, unless it has its own RemoteCap
+}
+
+// the limits for groups in a guild
+func (bs *BotState) limits(guildID string) Limits {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	l := Limits{GroupCap: DefaultGroupCap, RemoteCap: DefaultRemoteCap}
+	if n, ok := bs.GroupCaps[guildID]; ok {
+		l.GroupCap = n
+	}
+	if n, ok := bs.RemoteCaps[guildID]; ok {
+		l.RemoteCap = n
+	} else if n, ok := bs.RemoteCaps[""]; ok {
+		// set before remote caps were per guild
+		l.RemoteCap = n
+	}
+	return l
+}
+
+func (bs *BotState) remoteCapCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !remotecap - show the cap
+	//  - !remotecap <n> - set how many remote accounts the server's groups take
+	//  - !remotecap <raid> <group> <n>|default - set it for one group
+	args := strings.Fields(query)
+	usage := "<@" + m.Author.ID + "> use `!remotecap <n>` or `!remotecap <raid> <group number> <n|default>`"
+	guildID := channelGuild(s, m.ChannelID)
+	switch {
+	case len(args) == 0:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> groups take up to %d remote players",
+			m.Author.ID, bs.limits(guildID).RemoteCap))
+		return
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+		if guildID == "" {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> set the remote cap from a channel on your server")
+			return
+		}
+		if !isModerator(s, m.Author.ID, m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> only moderators can change how many remote players groups take")
+			return
+		}
+		bs.mut.Lock()
+		if bs.RemoteCaps == nil {
+			bs.RemoteCaps = make(map[string]int)
+		}
+		bs.RemoteCaps[guildID] = n
+		bs.recordSettings()
+		bs.mut.Unlock()
+
+		for _, raid := range bs.raidList() {
+			bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+				if raid.GuildID == guildID {
+					bs.updatePost(out, raid)
+				}
+			})
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Got it! Groups take up to %d remote players",
+			m.Author.ID, n))
+		return
+	case len(args) == 2:
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	bs.setGroupLimit(s, m, args, usage, func(out *deferred, rg *Group, n int) string {
+		if n < 0 {
+			rg.RemoteCap = nil
+		} else {
+			rg.RemoteCap = &n
+		}
+		return fmt.Sprintf("Group %d takes up to %d remote players",
+			rg.Number, rg.remoteCapacity(bs.limits(rg.raid.GuildID).RemoteCap))
+	})
+}
@@ -0,0 +1,171 @@
+package raid
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how a user wants to be told about their raids: group reminders, groups
+// starting, moving or being cancelled, and spots opening up
+const (
+	NotifyMention = "" // @mention in the raid channel
+	NotifyDM      = "dm"
+	NotifyOff     = "off"
+)
+
+// Notifier tells users about their raids, each the way they asked
+type Notifier interface {
+	Notify(channelID string, users map[string]int, msg string)
+}
+
+// parse a time of day like "22:00", "10pm" or "10:30pm" as "15:04"
+func parseClock(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, layout := range []string{"15:04", "3:04pm", "3pm"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("15:04"), true
+		}
+	}
+	return "", false
+}
+
+// whether t falls in the user's quiet hours, which may run past midnight
+func (u UserSettings) quiet(t time.Time) bool {
+	if u.QuietStart == "" || u.QuietEnd == "" {
+		return false
+	}
+	now := t.Format("15:04")
+	if u.QuietStart <= u.QuietEnd {
+		return now >= u.QuietStart && now < u.QuietEnd
+	}
+	return now >= u.QuietStart || now < u.QuietEnd
+}
+
+// whether to tell a user anything now
+func (bs *BotState) wantsNotice(userID string) bool {
+	u := bs.userSettings(userID)
+	return u.Notify != NotifyOff && !u.quiet(bs.clock.Now())
+}
+
+// DM a user, when the deferred calls are made
+func (bs *BotState) dm(out *deferred, userID, msg string) {
+	out.do(func(s Session) {
+		ch, err := bs.userChannel(s, userID)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if _, err := s.ChannelMessageSend(ch, msg); err != nil {
+			log.Print(err)
+		}
+	})
+}
+
+// tell users msg, about a raid in channelID: those who take mentions are
+// @mentioned there together, those who asked for DMs get one, and nobody
+// who's turned notifications off or is in their quiet hours hears anything;
+// must hold the raid's lock
+func (bs *BotState) notify(out *deferred, channelID string, users map[string]int, msg string) {
+	now := bs.clock.Now()
+	mentions := make(map[string]int)
+	for userID, count := range users {
+		u := bs.userSettings(userID)
+		if u.quiet(now) {
+			continue
+		}
+		switch u.Notify {
+		case NotifyOff:
+		case NotifyDM:
+			bs.dm(out, userID, msg)
+		default:
+			mentions[userID] = count
+		}
+	}
+	if len(mentions) > 0 {
+		out.ChannelMessageSend(channelID, (&Group{Members: mentions}).Mentions()+" "+msg)
+	}
+}
+
+// a Notifier sending through out
+type notifier struct {
+	bs  *BotState
+	out *deferred
+}
+
+func (n notifier) Notify(channelID string, users map[string]int, msg string) {
+	n.bs.notify(n.out, channelID, users, msg)
+}
+
+func (bs *BotState) notifier(out *deferred) Notifier {
+	return notifier{bs, out}
+}
+
+// like "by mention", for showing a setting
+func notifyHow(how string) string {
+	switch how {
+	case NotifyDM:
+		return "by DM"
+	case NotifyOff:
+		return "never (turned off)"
+	}
+	return "by mention"
+}
+
+// the setting named by a command argument, if it is one
+func parseNotify(arg string) (string, bool) {
+	switch arg {
+	case "mention", "on":
+		return NotifyMention, true
+	case NotifyOff, "none":
+		return NotifyOff, true
+	case NotifyDM:
+		return NotifyDM, true
+	}
+	return "", false
+}
+
+func (bs *BotState) notifyCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !notify - show settings
+	//  - !notify mention|dm|off - how to tell you about your raids
+	//  - !notify quiet <from>-<to>|off - when not to, e.g. 10pm-7am
+	args := strings.Fields(strings.ToLower(query))
+	u := bs.userSettings(m.Author.ID)
+	if len(args) == 0 {
+		quiet := ""
+		if u.QuietStart != "" {
+			quiet = fmt.Sprintf(", except from %s to %s", u.QuietStart, u.QuietEnd)
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> I tell you about your raids %s%s",
+			m.Author.ID, notifyHow(u.Notify), quiet))
+		return
+	}
+
+	if how, ok := parseNotify(args[0]); ok {
+		u.Notify = how
+	} else if args[0] == "quiet" && len(args) == 2 && args[1] == "off" {
+		u.QuietStart, u.QuietEnd = "", ""
+	} else if args[0] == "quiet" && len(args) > 1 {
+		span := strings.SplitN(strings.Join(args[1:], ""), "-", 2)
+		if len(span) != 2 {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!notify quiet <from>-<to>`, e.g. `!notify quiet 10pm-7am`")
+			return
+		}
+		from, ok1 := parseClock(span[0])
+		to, ok2 := parseClock(span[1])
+		if !ok1 || !ok2 || from == to {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!notify quiet <from>-<to>`, e.g. `!notify quiet 10pm-7am`")
+			return
+		}
+		u.QuietStart, u.QuietEnd = from, to
+	} else {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!notify [mention|dm|off]` or `!notify quiet <from>-<to>|off`")
+		return
+	}
+	bs.setUserSettings(m.Author.ID, u)
+	s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> Got it!")
+}
@@ -0,0 +1,52 @@
+package raid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserSettings_Quiet(t *testing.T) {
+	at := func(s string) time.Time {
+		t, _ := time.Parse("15:04", s)
+		return t
+	}
+	from, _ := parseClock("10pm")
+	to, _ := parseClock("7:00am")
+	night := UserSettings{QuietStart: from, QuietEnd: to}
+	if from != "22:00" || to != "07:00" || !night.quiet(at("23:30")) || !night.quiet(at("06:59")) || night.quiet(at("07:00")) {
+		t.Fatal("overnight quiet hours", night)
+	}
+	lunch := UserSettings{QuietStart: "12:00", QuietEnd: "13:00"}
+	if !lunch.quiet(at("12:30")) || lunch.quiet(at("13:30")) || (UserSettings{}).quiet(at("12:30")) {
+		t.Fatal("quiet hours")
+	}
+}
+
+func TestBotState_Notify(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	bs.setUserSettings("bob", UserSettings{Notify: NotifyDM})
+	bs.setUserSettings("carol", UserSettings{Notify: NotifyOff})
+	bs.setUserSettings("dave", UserSettings{QuietStart: "15:00", QuietEnd: "17:00"})
+	bs.setUserSettings("erin", UserSettings{QuietStart: "22:00", QuietEnd: "07:00"})
+
+	r := newTestRaid(t, bs, "raid")
+	rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	rg.Members = map[string]int{"alice": 2, "bob": 1, "carol": 1, "dave": 1, "erin": 1}
+	bs.scheduleRaid(r)
+
+	clock.Advance(40 * time.Minute)
+	bs.sched.RunDue(clock.Now())
+	bs.outbox.Flush()
+	start := s.sentWith("raids: <@")
+	t.Log(s.sent)
+	if len(start) != 1 || !strings.HasPrefix(start[0], "raids: <@alice> (x2) <@erin> ") ||
+		!strings.HasSuffix(start[0], "starting now!") {
+		t.Fatal("wrong mentions", start)
+	}
+	if len(s.sentWith("dm-bob: ")) != 1 || len(s.sentWith("dm-carol")) != 0 || len(s.sentWith("dm-dave")) != 0 {
+		t.Fatal("wrong DMs")
+	}
+}
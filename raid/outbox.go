@@ -0,0 +1,298 @@
+package raid
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxRetries = 5
+)
+
+type opKind int
+
+const (
+	opSend opKind = iota
+	opEdit
+	opUnpin
+	opReact
+	opUnreact // batch of reaction removals from one message
+	opUnreactAll
+)
+
+type reaction struct {
+	emoji, userID string
+}
+
+type outboxOp struct {
+	kind      opKind
+	channelID string
+	messageID string
+	content   string     // opSend, opEdit
+	emoji     string     // opReact
+	removals  []reaction // opUnreact
+
+	sent func(*discordgo.Message) // opSend: called with the message once it's out
+}
+
+// Outbox queues discord calls and makes them in the background, so nobody
+// waits on HTTP. Edits to a message still waiting to go out are coalesced,
+// so only its latest content is sent, and reaction removals from a message
+// are batched together. Calls failing with 429 or 5xx are retried with
+// exponential backoff.
+//
+// Outbox implements Session, but its calls return nothing useful, and
+// UserChannelCreate, which needs its result, goes straight through. A
+// message whose id is needed can be sent with ChannelMessageSendThen.
+type Outbox struct {
+	s Session
+
+	Backoff    time.Duration // first retry delay, doubling each retry
+	MaxRetries int
+	sleep      func(time.Duration)
+
+	mut      sync.Mutex
+	queue    []*outboxOp
+	edits    map[string]*outboxOp // message id -> queued edit
+	removals map[string]*outboxOp // message id -> queued reaction removals
+	running  bool
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewOutbox(s Session) *Outbox {
+	return &Outbox{
+		s:          s,
+		Backoff:    defaultBackoff,
+		MaxRetries: defaultMaxRetries,
+		sleep:      time.Sleep,
+		edits:      make(map[string]*outboxOp),
+		removals:   make(map[string]*outboxOp),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Depth is the number of queued calls (a batch of removals counts once)
+func (o *Outbox) Depth() int {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	return len(o.queue)
+}
+
+func (o *Outbox) push(op *outboxOp) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	switch op.kind {
+	case opEdit:
+		if queued, ok := o.edits[op.messageID]; ok {
+			queued.content = op.content
+			return
+		}
+		o.edits[op.messageID] = op
+	case opUnreact:
+		if queued, ok := o.removals[op.messageID]; ok {
+			for _, r := range queued.removals {
+				if r == op.removals[0] {
+					return
+				}
+			}
+			queued.removals = append(queued.removals, op.removals[0])
+			return
+		}
+		o.removals[op.messageID] = op
+	case opUnreactAll:
+		// takes care of any removals still waiting
+		if queued, ok := o.removals[op.messageID]; ok {
+			queued.removals = nil
+			delete(o.removals, op.messageID)
+		}
+	}
+	o.queue = append(o.queue, op)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) pop() (*outboxOp, bool) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	if len(o.queue) == 0 {
+		return nil, false
+	}
+	op := o.queue[0]
+	o.queue[0] = nil
+	o.queue = o.queue[1:]
+	if o.edits[op.messageID] == op {
+		delete(o.edits, op.messageID)
+	}
+	if o.removals[op.messageID] == op {
+		delete(o.removals, op.messageID)
+	}
+	return op, true
+}
+
+// whether a failed call is worth trying again
+func retryable(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// the HTTP status of a failed discord call, or 0 if it didn't get one
+func statusCode(err error) int {
+	var resp *http.Response
+	switch e := err.(type) {
+	case *discordgo.RESTError:
+		resp = e.Response
+	case discordgo.RESTError:
+		resp = e.Response
+	}
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func (o *Outbox) retry(f func() error) error {
+	backoff := o.Backoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= o.MaxRetries || !retryable(err) {
+			return err
+		}
+		log.Printf("retrying in %s: %s", backoff, err)
+		o.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (o *Outbox) send(op *outboxOp) {
+	var err error
+	switch op.kind {
+	case opSend:
+		var msg *discordgo.Message
+		err = o.retry(func() error {
+			var err error
+			msg, err = o.s.ChannelMessageSend(op.channelID, op.content)
+			return err
+		})
+		if err == nil && op.sent != nil {
+			op.sent(msg)
+		}
+	case opEdit:
+		err = o.retry(func() error {
+			_, err := o.s.ChannelMessageEdit(op.channelID, op.messageID, op.content)
+			return err
+		})
+	case opUnpin:
+		err = o.retry(func() error {
+			return o.s.ChannelMessageUnpin(op.channelID, op.messageID)
+		})
+	case opReact:
+		err = o.retry(func() error {
+			return o.s.MessageReactionAdd(op.channelID, op.messageID, op.emoji)
+		})
+	case opUnreact:
+		for _, r := range op.removals {
+			logErr(o.retry(func() error {
+				return o.s.MessageReactionRemove(op.channelID, op.messageID, r.emoji, r.userID)
+			}))
+		}
+	case opUnreactAll:
+		err = o.retry(func() error {
+			return o.s.MessageReactionsRemoveAll(op.channelID, op.messageID)
+		})
+	}
+	logErr(err)
+}
+
+// Flush makes every queued call now
+func (o *Outbox) Flush() {
+	for {
+		op, ok := o.pop()
+		if !ok {
+			return
+		}
+		o.send(op)
+	}
+}
+
+// Run makes queued calls as they come in until Stop is called
+func (o *Outbox) Run() {
+	o.mut.Lock()
+	o.running = true
+	o.mut.Unlock()
+	defer close(o.done)
+	for {
+		o.Flush()
+		select {
+		case <-o.wake:
+		case <-o.stop:
+			o.Flush()
+			return
+		}
+	}
+}
+
+// Stop sends whatever is still queued and stops Run
+func (o *Outbox) Stop() {
+	close(o.stop)
+	o.mut.Lock()
+	running := o.running
+	o.mut.Unlock()
+	if running {
+		<-o.done
+	} else {
+		o.Flush()
+	}
+}
+
+func (o *Outbox) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	o.push(&outboxOp{kind: opSend, channelID: channelID, content: content})
+	return nil, nil
+}
+
+// ChannelMessageSendThen queues a message like ChannelMessageSend, and calls
+// sent with it from the outbox once it's out; sent isn't called if sending
+// fails for good
+func (o *Outbox) ChannelMessageSendThen(channelID, content string, sent func(*discordgo.Message)) {
+	o.push(&outboxOp{kind: opSend, channelID: channelID, content: content, sent: sent})
+}
+
+func (o *Outbox) ChannelMessageEdit(channelID, messageID, content string) (*discordgo.Message, error) {
+	o.push(&outboxOp{kind: opEdit, channelID: channelID, messageID: messageID, content: content})
+	return nil, nil
+}
+
+func (o *Outbox) ChannelMessageUnpin(channelID, messageID string) error {
+	o.push(&outboxOp{kind: opUnpin, channelID: channelID, messageID: messageID})
+	return nil
+}
+
+func (o *Outbox) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	o.push(&outboxOp{kind: opReact, channelID: channelID, messageID: messageID, emoji: emojiID})
+	return nil
+}
+
+func (o *Outbox) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	o.push(&outboxOp{kind: opUnreact, channelID: channelID, messageID: messageID,
+		removals: []reaction{{emojiID, userID}}})
+	return nil
+}
+
+func (o *Outbox) MessageReactionsRemoveAll(channelID, messageID string) error {
+	o.push(&outboxOp{kind: opUnreactAll, channelID: channelID, messageID: messageID})
+	return nil
+}
+
+func (o *Outbox) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	return o.s.UserChannelCreate(recipientID)
+}
@@ -0,0 +1,66 @@
+package raid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestOutbox(t *testing.T) {
+	s := &fakeSession{}
+	o := NewOutbox(s)
+	var slept []time.Duration
+	o.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+	}
+
+	// edits to the same message coalesce, keeping their place in the queue
+	o.ChannelMessageEdit("raids", "raid", "one")
+	o.ChannelMessageSend("raids", "hello")
+	o.ChannelMessageEdit("raids", "raid", "two")
+	o.ChannelMessageEdit("raids", "other", "three")
+	// removals from the same message batch, dropping repeats
+	o.MessageReactionRemove("raids", "raid", "1", "a")
+	o.MessageReactionRemove("raids", "raid", "1", "b")
+	o.MessageReactionRemove("raids", "raid", "1", "a")
+	if o.Depth() != 4 {
+		t.Fatal("queue depth", o.Depth())
+	}
+
+	s.fail = 2
+	o.Flush()
+	if len(s.edits) != 2 || s.edits[0] != "raid: two" || len(s.sent) != 1 || s.removals != 2 {
+		t.Fatal(s.edits, s.sent, s.removals)
+	}
+	if len(slept) != 2 || slept[1] != 2*slept[0] {
+		t.Fatal("backoff", slept)
+	}
+	if o.Depth() != 0 {
+		t.Fatal("queue depth", o.Depth())
+	}
+
+	// give up after MaxRetries
+	s.fail = o.MaxRetries + 2
+	o.ChannelMessageSend("raids", "dropped")
+	o.Flush()
+	if len(s.sent) != 1 {
+		t.Fatal(s.sent)
+	}
+
+	// a message whose id is needed gets it once it's out, retries and all,
+	// and not at all if it never goes
+	var sent []string
+	save := func(msg *discordgo.Message) {
+		sent = append(sent, msg.ID)
+	}
+	s.fail = 1
+	o.ChannelMessageSendThen("raids", "asking", save)
+	o.Flush()
+	s.fail = o.MaxRetries + 1
+	o.ChannelMessageSendThen("raids", "dropped", save)
+	o.Flush()
+	if len(sent) != 1 || len(s.sent) != 2 {
+		t.Fatal(sent, s.sent)
+	}
+}
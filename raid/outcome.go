@@ -0,0 +1,352 @@
+package raid
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how long after a group starts its members can report how it went
+const outcomeWindow = 30 * time.Minute
+
+// outcome reactions; a keycap digit reports the number of accounts
+const (
+	outcomeWonEmoji     = "✅"
+	outcomeLostEmoji    = "❌"
+	outcomeShinyEmoji   = "✨"
+	outcomeBoostedEmoji = "⛅"
+)
+
+// OutcomeReport.Result values
+const (
+	OutcomeWon  = "won"
+	OutcomeLost = "lost"
+)
+
+// OutcomeReport is one member's report of how a group went
+type OutcomeReport struct {
+	Result   string `json:"result,omitempty"`   // OutcomeWon, OutcomeLost or unreported
+	Accounts int    `json:"accounts,omitempty"` // accounts they raided with, 0 if unreported
+	Shiny    bool   `json:"shiny,omitempty"`
+	Boosted  bool   `json:"boosted,omitempty"` // weather boosted
+}
+
+// OutcomeRecord collects the reports for a group, from reactions on a
+// message posted when it starts
+type OutcomeRecord struct {
+	MessageID string                    `json:"msg_id"` // the message taking reports
+	ChannelID string                    `json:"channel_id"`
+	GuildID   string                    `json:"guild_id,omitempty"`
+	Raid      string                    `json:"raid"`  // raid message id
+	Group     int                       `json:"group"` // group number, from 1
+	Boss      string                    `json:"boss"`
+	GymName   string                    `json:"gym_name"`
+	StartTime time.Time                 `json:"start_time"`
+	Closes    time.Time                 `json:"closes"`
+	Members   []string                  `json:"members"` // userids who can report
+	Reports   map[string]*OutcomeReport `json:"reports"`
+}
+
+func (o *OutcomeRecord) member(userID string) bool {
+	for _, id := range o.Members {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// the group's result: won if any members say so and no more say it was lost
+func (o *OutcomeRecord) Result() string {
+	won, lost := 0, 0
+	for _, r := range o.Reports {
+		switch r.Result {
+		case OutcomeWon:
+			won++
+		case OutcomeLost:
+			lost++
+		}
+	}
+	if won > 0 && won >= lost {
+		return OutcomeWon
+	} else if lost > 0 {
+		return OutcomeLost
+	}
+	return ""
+}
+
+// total accounts reported, shinies caught and whether it was boosted
+func (o *OutcomeRecord) totals() (accounts, shinies int, boosted bool) {
+	for _, r := range o.Reports {
+		accounts += r.Accounts
+		if r.Shiny {
+			shinies++
+		}
+		boosted = boosted || r.Boosted
+	}
+	return
+}
+
+func (o *OutcomeRecord) summary() string {
+	result := o.Result()
+	if result == "" {
+		result = "no result reported"
+	}
+	accounts, shinies, boosted := o.totals()
+	s := fmt.Sprintf("Group %d %s raid at %s: %s", o.Group, o.Boss, o.GymName, result)
+	if accounts > 0 {
+		s += fmt.Sprintf(", %d accounts", accounts)
+	}
+	if shinies > 0 {
+		s += fmt.Sprintf(", %d shiny %s", shinies, outcomeShinyEmoji)
+	}
+	if boosted {
+		s += ", weather boosted"
+	}
+	return s
+}
+
+// proxy type for an outcome report message
+type Outcome struct {
+	Record *OutcomeRecord
+}
+
+func (o *Outcome) OnReactionAdd(bs *BotState, s Session, m *discordgo.MessageReactionAdd) {
+	bs.reportOutcome(o.Record, m.UserID, m.Emoji.Name, true)
+}
+
+func (o *Outcome) OnReactionRemove(bs *BotState, s Session, m *discordgo.MessageReactionRemove) {
+	bs.reportOutcome(o.Record, m.UserID, m.Emoji.Name, false)
+}
+
+func (o *Outcome) OnMessageEdit(bs *BotState, s *discordgo.Session, m *discordgo.MessageUpdate) {
+	// no-op
+}
+
+func (o *Outcome) OnMessageDelete(bs *BotState, s *discordgo.Session, m *discordgo.MessageDelete) {
+	bs.closeOutcome(o.Record.MessageID)
+}
+
+// what to ask about a group that just started
+type outcomeAsk struct {
+	raid    *Raid
+	group   int
+	start   time.Time
+	members []string
+}
+
+// the outcome question for a group about to start; must hold raid.mut
+func newOutcomeAsk(raid *Raid, rg *Group) *outcomeAsk {
+	if len(rg.Members) == 0 {
+		return nil
+	}
+	ask := &outcomeAsk{raid: raid, group: rg.Number, start: rg.StartTime}
+	for id := range rg.Members {
+		ask.members = append(ask.members, id)
+	}
+	sort.Strings(ask.members)
+	return ask
+}
+
+// post the message taking outcome reports for a group that's started. Reports
+// are keyed by its id, so the group's outcome is only taken once the outbox
+// has it out.
+func (bs *BotState) askOutcome(ask *outcomeAsk) {
+	raid := ask.raid
+	raid.mut.Lock()
+	o := &OutcomeRecord{
+		ChannelID: raid.ChannelID,
+		GuildID:   raid.GuildID,
+		Raid:      raid.MessageID,
+		Group:     ask.group,
+		Boss:      raid.What,
+		GymName:   raid.Gym.Name,
+		StartTime: ask.start,
+		Closes:    bs.clock.Now().Add(outcomeWindow),
+		Members:   ask.members,
+		Reports:   make(map[string]*OutcomeReport),
+	}
+	raid.mut.Unlock()
+
+	bs.outbox.ChannelMessageSendThen(o.ChannelID, fmt.Sprintf(
+		"How did group %d at %s go? React %s won, %s lost, 1%s-9%s for the accounts you used, "+
+			"%s if you caught a shiny and %s if it was weather boosted.",
+		o.Group, o.GymName, outcomeWonEmoji, outcomeLostEmoji, boxEmoji, boxEmoji,
+		outcomeShinyEmoji, outcomeBoostedEmoji), func(msg *discordgo.Message) {
+		o.MessageID = msg.ID
+		for _, emoji := range []string{outcomeWonEmoji, outcomeLostEmoji, outcomeShinyEmoji, outcomeBoostedEmoji} {
+			bs.outbox.MessageReactionAdd(o.ChannelID, o.MessageID, emoji)
+		}
+
+		bs.mut.Lock()
+		bs.Outcomes[o.MessageID] = o
+		bs.activeMessages[o.MessageID] = &Outcome{o}
+		bs.recordOutcome(o.MessageID)
+		bs.mut.Unlock()
+		bs.sched.Schedule(Event{When: o.Closes, Kind: EventOutcomeClose, RaidID: o.MessageID, Group: -1})
+	})
+}
+
+// apply an outcome reaction added or removed by a member
+func (bs *BotState) reportOutcome(o *OutcomeRecord, userID, emoji string, add bool) {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	if bs.Outcomes[o.MessageID] != o || !o.member(userID) {
+		return
+	}
+	r := o.Reports[userID]
+	if r == nil {
+		r = &OutcomeReport{}
+		o.Reports[userID] = r
+	}
+	switch {
+	case emoji == outcomeWonEmoji || emoji == outcomeLostEmoji:
+		result := OutcomeWon
+		if emoji == outcomeLostEmoji {
+			result = OutcomeLost
+		}
+		if add {
+			r.Result = result
+		} else if r.Result == result {
+			r.Result = ""
+		}
+	case emoji == outcomeShinyEmoji:
+		r.Shiny = add
+	case emoji == outcomeBoostedEmoji:
+		r.Boosted = add
+	case len(emoji) > 1 && emoji[1:] == boxEmoji && emoji[0] >= '1' && emoji[0] <= '9':
+		n := int(emoji[0] - '0')
+		if add {
+			r.Accounts = n
+		} else if r.Accounts == n {
+			r.Accounts = 0
+		}
+	}
+	if *r == (OutcomeReport{}) {
+		delete(o.Reports, userID)
+	}
+	bs.recordOutcome(o.MessageID)
+}
+
+// stop taking reports for a group, archive them and post a summary
+func (bs *BotState) closeOutcome(messageID string) {
+	bs.mut.Lock()
+	o, ok := bs.Outcomes[messageID]
+	if ok {
+		delete(bs.Outcomes, messageID)
+		delete(bs.activeMessages, messageID)
+		bs.recordOutcome(messageID)
+	}
+	bs.mut.Unlock()
+	if !ok {
+		return
+	}
+	bs.sched.CancelRaid(messageID)
+
+	log.Printf("outcome: %s", o.summary())
+	bs.outbox.MessageReactionsRemoveAll(o.ChannelID, o.MessageID)
+	if len(o.Reports) == 0 {
+		return
+	}
+	bs.outbox.ChannelMessageEdit(o.ChannelID, o.MessageID, o.summary())
+	if bs.history != nil {
+		logErr(bs.history.AddOutcome(o))
+	}
+}
+
+// record an outcome's reports, or that it's closed; must hold bs.mut
+func (bs *BotState) recordOutcome(messageID string) {
+	rec := &outcomeRecord{ID: messageID}
+	if o, ok := bs.Outcomes[messageID]; ok {
+		data, err := json.Marshal(o)
+		if err != nil {
+			log.Print(err)
+			bs.markDirty()
+			return
+		}
+		rec.Data = data
+	}
+	bs.appendRecord(journalRecord{Outcome: rec})
+}
+
+// bossOutcomes summarizes the reported outcomes for each boss
+type bossOutcomes struct {
+	groups, won, lost  int
+	accounts, reported int // accounts, and groups reporting them
+	shinies, raiders   int // shinies caught, by raiders reporting
+	boosted            int
+}
+
+func (b *bossOutcomes) String() string {
+	s := fmt.Sprintf("%d groups, %d won, %d lost", b.groups, b.won, b.lost)
+	if b.reported > 0 {
+		s += fmt.Sprintf(", %.1f accounts per group", float64(b.accounts)/float64(b.reported))
+	}
+	s += fmt.Sprintf(", %d shinies from %d raiders, %d boosted", b.shinies, b.raiders, b.boosted)
+	return s
+}
+
+func outcomesByBoss(outcomes []*OutcomeRecord) map[string]*bossOutcomes {
+	bosses := make(map[string]*bossOutcomes)
+	for _, o := range outcomes {
+		boss := strings.ToLower(o.Boss)
+		b := bosses[boss]
+		if b == nil {
+			b = &bossOutcomes{}
+			bosses[boss] = b
+		}
+		b.groups++
+		switch o.Result() {
+		case OutcomeWon:
+			b.won++
+		case OutcomeLost:
+			b.lost++
+		}
+		accounts, shinies, boosted := o.totals()
+		if accounts > 0 {
+			b.accounts += accounts
+			b.reported++
+		}
+		b.shinies += shinies
+		b.raiders += len(o.Reports)
+		if boosted {
+			b.boosted++
+		}
+	}
+	return bosses
+}
+
+// per-boss outcome summaries, or just the one boss if it's given
+func bossStats(outcomes []*OutcomeRecord, boss string) string {
+	bosses := outcomesByBoss(outcomes)
+	if boss != "" {
+		b, ok := bosses[strings.ToLower(boss)]
+		if !ok {
+			return "No outcomes reported for " + boss + " yet."
+		}
+		return b.String()
+	}
+	if len(bosses) == 0 {
+		return "No outcomes reported yet."
+	}
+	names := make([]string, 0, len(bosses))
+	for name := range bosses {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if bosses[names[i]].groups != bosses[names[j]].groups {
+			return bosses[names[i]].groups > bosses[names[j]].groups
+		}
+		return names[i] < names[j]
+	})
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("**%s**: %s", name, bosses[name]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,201 @@
+package raid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how long before a group starts its hosts and remote players are put in
+// touch
+const inviteLead = 5 * time.Minute
+
+// Profile is what a user tells other raiders about themselves
+type Profile struct {
+	TrainerName string `json:"trainer_name,omitempty"`
+	Level       int    `json:"level,omitempty"`
+	FriendCode  string `json:"friend_code,omitempty"` // "1234 5678 9012"
+	Private     bool   `json:"private,omitempty"`     // don't hand out the friend code
+}
+
+// parse a 12 digit friend code, however it's spaced
+func parseFriendCode(s string) (string, bool) {
+	var digits []byte
+	for _, c := range []byte(s) {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '-':
+		default:
+			return "", false
+		}
+	}
+	if len(digits) != 12 {
+		return "", false
+	}
+	return string(digits[0:4]) + " " + string(digits[4:8]) + " " + string(digits[8:12]), true
+}
+
+// how to find a user in game: trainer name, level and friend code, with the
+// code left out if they keep it private
+func (p Profile) describe(userID string) string {
+	name := "<@" + userID + ">"
+	if p.TrainerName != "" {
+		name = fmt.Sprintf("**%s** (<@%s>)", p.TrainerName, userID)
+	}
+	if p.Level > 0 {
+		name += fmt.Sprintf(" level %d", p.Level)
+	}
+	switch {
+	case p.FriendCode == "":
+		name += ": no friend code set"
+	case p.Private:
+		name += ": friend code is private"
+	default:
+		name += ": `" + p.FriendCode + "`"
+	}
+	return name
+}
+
+// DM the group's hosts the remote players to invite, and the remote players
+// their hosts, once it's close to starting. Each is sent only those they
+// haven't been yet, so whoever joins or changes how they're joining later is
+// put in touch too. Returns whether anything was sent; must hold the raid's
+// lock.
+func (bs *BotState) sendInvites(out *deferred, rg *Group) bool {
+	if rg.Expired || bs.clock.Now().Before(rg.StartTime.Add(-inviteLead)) {
+		return false
+	}
+	var hosts, joiners []string
+	for userID := range rg.Members {
+		switch mode := rg.Modes[userID]; {
+		case mode == ModeHost:
+			hosts = append(hosts, userID)
+		case remoteMode(mode):
+			joiners = append(joiners, userID)
+		}
+	}
+	sort.Strings(hosts)
+	sort.Strings(joiners)
+
+	describe := func(ids []string) string {
+		var lines []string
+		for _, id := range ids {
+			line := bs.userSettings(id).Profile.describe(id)
+			if n := rg.Members[id]; n > 1 {
+				line += fmt.Sprintf(" (x%d)", n)
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	what := fmt.Sprintf("group %d for the %s at %s", rg.Number, rg.raid.String(), rg.StartTime.Format("3:04 PM"))
+	sent := false
+	// always by DM, since they hold friend codes, and whatever the user's
+	// notification settings: a remote raid can't go ahead without them
+	send := func(userID string, others []string, first, more string) {
+		var unsent []string
+	other:
+		for _, id := range others {
+			for _, told := range rg.Invited[userID] {
+				if told == id {
+					continue other
+				}
+			}
+			unsent = append(unsent, id)
+		}
+		if len(unsent) == 0 {
+			return
+		}
+		msg := first
+		if len(rg.Invited[userID]) > 0 {
+			msg = more
+		}
+		bs.dm(out, userID, fmt.Sprintf(msg, what, describe(unsent)))
+		if rg.Invited == nil {
+			rg.Invited = make(map[string][]string)
+		}
+		rg.Invited[userID] = append(rg.Invited[userID], unsent...)
+		sent = true
+	}
+	for _, id := range hosts {
+		send(id, joiners, "You're hosting %s. Remote players to invite (those with private codes will add you):\n%s",
+			"More remote players to invite to %s:\n%s")
+	}
+	for _, id := range joiners {
+		send(id, hosts, "You're joining %s remotely. Add a host so they can invite you (those with private codes will add you):\n%s",
+			"Another host for %s; add them so they can invite you:\n%s")
+	}
+	return sent
+}
+
+func (bs *BotState) profileCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !profile - show your profile
+	//  - !profile set name|level|code <value>
+	//  - !profile set private on|off
+	//  - !profile clear
+	args := strings.Fields(query)
+	usage := "<@" + m.Author.ID + "> use `!profile set name|level|code|private <value>` or `!profile clear`"
+	u := bs.userSettings(m.Author.ID)
+	if len(args) == 0 {
+		if u.Profile == (Profile{}) {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> you haven't set up a profile; "+
+				"use `!profile set name|level|code|private <value>`")
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, u.Profile.describe(m.Author.ID))
+		return
+	}
+
+	switch args[0] {
+	case "clear":
+		u.Profile = Profile{}
+	case "set":
+		if len(args) < 3 {
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+		value := strings.Join(args[2:], " ")
+		switch strings.ToLower(args[1]) {
+		case "name":
+			u.TrainerName = value
+		case "level":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 50 {
+				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> level must be 1 to 50")
+				return
+			}
+			u.Level = n
+		case "code":
+			code, ok := parseFriendCode(value)
+			if !ok {
+				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> friend codes are 12 digits, like `1234 5678 9012`")
+				return
+			}
+			u.FriendCode = code
+		case "private":
+			switch strings.ToLower(value) {
+			case "on", "yes", "true":
+				u.Private = true
+			case "off", "no", "false":
+				u.Private = false
+			default:
+				s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!profile set private on|off`")
+				return
+			}
+		default:
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+	default:
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+	bs.setUserSettings(m.Author.ID, u)
+	s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> Got it!")
+}
@@ -0,0 +1,76 @@
+package raid
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseFriendCode(t *testing.T) {
+	for s, want := range map[string]string{
+		"1234 5678 9012": "1234 5678 9012",
+		"123456789012":   "1234 5678 9012",
+		"1234-5678-9012": "1234 5678 9012",
+		"1234 5678 901":  "",
+		"1234 5678 901x": "",
+	} {
+		code, ok := parseFriendCode(s)
+		if code != want || ok != (want != "") {
+			t.Errorf("%q: got %q, %v", s, code, ok)
+		}
+	}
+}
+
+func TestBotState_SendInvites(t *testing.T) {
+	bs, clock, s, cleanup := newTestBotState(t)
+	defer cleanup()
+
+	bs.setUserSettings("alice", UserSettings{Profile: Profile{TrainerName: "Alice", Level: 40, FriendCode: "1111 2222 3333"}})
+	// invites go out whatever the players' notification settings
+	bs.setUserSettings("bob", UserSettings{Notify: NotifyOff,
+		Profile: Profile{TrainerName: "Bob", FriendCode: "4444 5555 6666", Private: true}})
+	bs.setUserSettings("carol", UserSettings{QuietStart: clock.Now().Format("15:04"),
+		QuietEnd: clock.Now().Add(3 * time.Hour).Format("15:04")})
+
+	r := newTestRaid(t, bs, "raid")
+	rg := r.AddGroup(clock.Now().Add(40*time.Minute), s)
+	rg.Members = map[string]int{"alice": 1, "bob": 1, "carol": 2, "dave": 1}
+	rg.setMode("alice", ModeHost, 10)
+	rg.setMode("bob", ModeInvite, 10)
+	rg.setMode("carol", ModeRemote, 10)
+	bs.scheduleRaid(r)
+
+	clock.Advance(40*time.Minute - inviteLead)
+	bs.sched.RunDue(clock.Now())
+	bs.outbox.Flush()
+	toHost, toBob := s.sentWith("dm-alice: "), s.sentWith("dm-bob: ")
+	if len(rg.Invited["alice"]) != 2 || len(toHost) != 1 || len(toBob) != 1 || len(s.sentWith("dm-carol: ")) != 1 ||
+		len(s.sentWith("dm-dave")) != 0 {
+		t.Fatal("wrong DMs", s.sent)
+	}
+	t.Log(toHost[0])
+	t.Log(toBob[0])
+	if strings.Contains(toHost[0], "4444") || !strings.Contains(toHost[0], "<@carol>: no friend code set (x2)") ||
+		!strings.Contains(toBob[0], "**Alice** (<@alice>) level 40: `1111 2222 3333`") {
+		t.Fatal("wrong profiles")
+	}
+
+	// joining remotely later still puts erin in touch, and tells the host
+	// about erin alone
+	for _, emoji := range []string{"1" + boxEmoji, modeEmoji[ModeRemote]} {
+		bs.messageReactionAdd(s, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+			UserID: "erin", MessageID: "raid", ChannelID: "raids", Emoji: discordgo.Emoji{Name: emoji}}})
+	}
+	bs.outbox.Flush()
+	toHost, toErin := s.sentWith("dm-alice: "), s.sentWith("dm-erin: ")
+	if len(toHost) != 2 || len(toErin) != 1 || len(s.sentWith("dm-bob: ")) != 1 {
+		t.Fatal("wrong late DMs", s.sent)
+	}
+	t.Log(toHost[1])
+	if !strings.Contains(toHost[1], "<@erin>") || strings.Contains(toHost[1], "carol") ||
+		!strings.Contains(toErin[0], "<@alice>") {
+		t.Fatal("wrong late profiles")
+	}
+}
@@ -0,0 +1,217 @@
+package raid
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how long the bot waits for an answer to a prompt
+const promptTimeout = 15 * time.Minute
+
+type PromptKind string
+
+// prompt kinds
+const (
+	PromptGroupTime PromptKind = "group_time" // start time of a new group in Prompt.RaidID
+)
+
+// Prompt is a question the bot has DMed a user and is waiting on an answer
+// to. They're saved with the state so answers still work after a restart.
+type Prompt struct {
+	Kind      PromptKind `json:"kind"`
+	RaidID    string     `json:"raid"`
+	UserID    string     `json:"user"`
+	ChannelID string     `json:"channel"` // DM channel the answer comes in on
+	Title     string     `json:"title"`   // what it's about, to choose between prompts
+	Expires   time.Time  `json:"expires"`
+	Selected  bool       `json:"selected,omitempty"` // chosen from several pending prompts
+}
+
+// DM the user asking for a group time for raid, in reply to ⏰
+func (bs *BotState) promptGroupTime(s Session, userID string, raid *Raid, title string) {
+	ch, err := bs.userChannel(s, userID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	p := &Prompt{
+		Kind:      PromptGroupTime,
+		RaidID:    raid.MessageID,
+		UserID:    userID,
+		ChannelID: ch,
+		Title:     title,
+		Expires:   bs.clock.Now().Add(promptTimeout),
+	}
+	bs.mut.Lock()
+	var prompts []*Prompt
+	for _, q := range bs.Prompts[userID] {
+		// asking again about the same raid starts over
+		if q.Kind != p.Kind || q.RaidID != p.RaidID {
+			q.Selected = false
+			prompts = append(prompts, q)
+		}
+	}
+	prompts = append(prompts, p)
+	bs.Prompts[userID] = prompts
+	bs.recordPrompts(userID)
+	msg := fmt.Sprintf("Adding a raid group for %s; what time?", title)
+	if len(prompts) > 1 {
+		msg = fmt.Sprintf("Adding a raid group for %s. You're adding groups to several raids; "+
+			"reply with a number and a time, e.g. `%d 4:30pm`, or `cancel`:\n%s",
+			title, len(prompts), formatPrompts(prompts))
+	}
+	bs.mut.Unlock()
+
+	_, err = s.ChannelMessageSend(ch, msg)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func formatPrompts(prompts []*Prompt) string {
+	var lines []string
+	for n, p := range prompts {
+		lines = append(lines, fmt.Sprintf("%d. %s", n+1, p.Title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handle a DM answering a pending prompt. Returns false if the message
+// wasn't one; commands never are.
+func (bs *BotState) promptReply(m *discordgo.MessageCreate) bool {
+	if strings.HasPrefix(m.Content, commandLeader) {
+		return false
+	}
+	userID := m.Author.ID
+	text := strings.TrimSpace(m.Content)
+	now := bs.clock.Now()
+	reply := func(msg string) {
+		bs.outbox.ChannelMessageSend(m.ChannelID, msg)
+	}
+
+	bs.mut.Lock()
+	var live []*Prompt
+	answered := false
+	for _, p := range bs.Prompts[userID] {
+		if p.ChannelID != m.ChannelID {
+			continue
+		}
+		answered = true
+		if p.Expires.After(now) {
+			live = append(live, p)
+		}
+	}
+	if !answered {
+		bs.mut.Unlock()
+		return false
+	}
+	if len(live) == 0 || strings.EqualFold(text, "cancel") {
+		delete(bs.Prompts, userID)
+		bs.recordPrompts(userID)
+		bs.mut.Unlock()
+		if len(live) == 0 {
+			reply("Sorry, that took too long. Click ⏰ on the raid again to add a group.")
+		} else {
+			reply("Ok, cancelled.")
+		}
+		return true
+	}
+	if len(live) < len(bs.Prompts[userID]) {
+		bs.Prompts[userID] = live
+		bs.recordPrompts(userID)
+	}
+
+	var target *Prompt
+	for _, p := range live {
+		if p.Selected {
+			target = p
+		}
+	}
+	if target == nil && len(live) == 1 {
+		target = live[0]
+	}
+	if target == nil {
+		// choose one first
+		fields := strings.Fields(text)
+		n := 0
+		if len(fields) > 0 {
+			n, _ = strconv.Atoi(fields[0])
+		}
+		if n < 1 || n > len(live) {
+			bs.mut.Unlock()
+			reply(fmt.Sprintf("Which raid? Reply with a number and a time, e.g. `1 4:30pm`, or `cancel`:\n%s",
+				formatPrompts(live)))
+			return true
+		}
+		target = live[n-1]
+		target.Selected = true
+		bs.recordPrompts(userID)
+		text = strings.TrimSpace(text[len(fields[0]):])
+		if text == "" {
+			bs.mut.Unlock()
+			reply(fmt.Sprintf("What time for %s?", target.Title))
+			return true
+		}
+	}
+	bs.mut.Unlock()
+
+	added := false
+	ok := bs.withRaid(target.RaidID, func(raid *Raid, out *deferred) {
+		log.Printf("got time from %s for raid %s: %s", m.Author.Username, raid.String(), text)
+		added = bs.addGroupFromDM(out, raid, m.Author.ID, m.ChannelID, text)
+	})
+	if !ok {
+		reply(fmt.Sprintf("The raid at %s is over.", target.Title))
+	}
+	if added || !ok {
+		bs.removePrompt(target)
+	}
+	return true
+}
+
+func (bs *BotState) removePrompt(p *Prompt) {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	var prompts []*Prompt
+	for _, q := range bs.Prompts[p.UserID] {
+		if q != p {
+			prompts = append(prompts, q)
+		}
+	}
+	if len(prompts) == 0 {
+		delete(bs.Prompts, p.UserID)
+	} else {
+		bs.Prompts[p.UserID] = prompts
+	}
+	bs.recordPrompts(p.UserID)
+}
+
+// drop the prompts nobody answered in time
+func (bs *BotState) expirePrompts() {
+	now := bs.clock.Now()
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	for userID, prompts := range bs.Prompts {
+		var live []*Prompt
+		for _, p := range prompts {
+			if p.Expires.After(now) {
+				live = append(live, p)
+			}
+		}
+		if len(live) == len(prompts) {
+			continue
+		}
+		if len(live) == 0 {
+			delete(bs.Prompts, userID)
+		} else {
+			bs.Prompts[userID] = live
+		}
+		bs.recordPrompts(userID)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"strings"
 	"github.com/bwmarrin/discordgo"
 	"log"
+	"sync"
+	"sort"
+	"strconv"
 )
 
 type Raid struct {
@@ -14,31 +17,136 @@ type Raid struct {
 	What         string     `json:"what"`
 	Emoji        string     `json:"emoji"` // latest reaction emoji, can indicate which pokemon
 	EndTime      time.Time  `json:"end_time"`
-	MessageID    string     `json:"msg_id"`     // discord pinned message id
-	ChannelID    string     `json:"channel_id"` // discord channel pinned in
+	MessageID    string     `json:"msg_id"`             // discord pinned message id
+	ChannelID    string     `json:"channel_id"`         // discord channel pinned in
+	GuildID      string     `json:"guild_id,omitempty"` // server the channel's in, for its settings
 	Groups       []*Group   `json:"groups"`
 	Hatched      bool       `json:"hatched"`
 	RequestMsgID string     `json:"req_msg_id"`
+	Request      string     `json:"request,omitempty"` // request message content the raid was last parsed from
+	Kind         string     `json:"kind,omitempty"`     // RaidKindNormal or RaidKindEx
+	Reminded     []string   `json:"reminded,omitempty"` // names of raid reminders already sent
 	expired      bool
+	start        time.Time // when the request last parsed asked group 1 to start, if it did
+
+	LastGroup int            `json:"last_group,omitempty"` // number of the latest group; numbers aren't reused
+	Picked    map[string]int `json:"picked,omitempty"`     // userid -> number of the group they joined last
+
+	// guards everything but MessageID, ChannelID, GuildID and RequestMsgID,
+	// which don't change once the raid is posted
+	mut sync.Mutex
+}
+
+// raid kinds
+const (
+	RaidKindNormal = ""
+	RaidKindEx     = "ex" // EX raid by invitation, scheduled days ahead
+)
+
+// EX raid reminders, sent this long before the raid hatches
+var exReminders = []struct {
+	name string
+	lead time.Duration
+	when string
+}{
+	{"day", 24 * time.Hour, "tomorrow"},
+	{"hour", time.Hour, "in an hour"},
 }
 
 // unicode to draw a box around the preceding character; with 1..9 forms a number emoji
 var boxEmoji = string([]byte{226, 131, 163})
 
-func (r *Raid) GenMessage() string {
+// the most groups a raid can have: 1⃣ to 9⃣, 🔟, then 🇦 to 🇿
+const maxGroups = 36
+
+// the most unexpired groups a raid can have at once; a message takes 20
+// different reactions, and ⏰, ➕, ➖, the four mode reactions and the 💙, ❤️
+// and 💛 hearts for bringing an account of a team need 10
+const maxActiveGroups = 10
+
+// regional indicator 🇦, which the letters follow
+const letterA = 0x1F1E6
+
+// the reaction for joining group n
+func groupEmoji(n int) string {
+	switch {
+	case n <= 9:
+		return strconv.Itoa(n) + boxEmoji
+	case n == 10:
+		return "🔟"
+	}
+	return string(rune(letterA + n - 11))
+}
+
+// the group a reaction is for, if it is one; discord may put a variation
+// selector in a keycap
+func emojiGroup(emoji string) (int, bool) {
+	emoji = strings.Replace(emoji, "\ufe0f", "", 1)
+	if len(emoji) == 1+len(boxEmoji) && emoji[0] >= '1' && emoji[0] <= '9' && emoji[1:] == boxEmoji {
+		return int(emoji[0] - '0'), true
+	}
+	if emoji == "🔟" {
+		return 10, true
+	}
+	if r := []rune(emoji); len(r) == 1 && r[0] >= letterA && r[0] < letterA+26 {
+		return 11 + int(r[0]-letterA), true
+	}
+	return 0, false
+}
+
+func (r *Raid) IsEx() bool {
+	return r.Kind == RaidKindEx
+}
+
+// HatchTime is when the raid boss appears
+func (r *Raid) HatchTime() time.Time {
+	return r.EndTime.Add(-RaidDuration)
+}
+
+// time format for the raid's times; EX raids are days away so include the date
+func (r *Raid) timeFormat() string {
+	if r.IsEx() {
+		return "Mon Jan 2 3:04 PM"
+	}
+	return "3:04 PM"
+}
+
+func (r *Raid) exTitle() string {
+	if r.What == "" {
+		return "EX raid"
+	}
+	return r.What + " EX raid"
+}
+
+func (r *Raid) reminded(name string) bool {
+	for _, n := range r.Reminded {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Raid) GenMessage(l Limits) string {
 	clockMsg := ""
 	if !r.expired {
 		if len(r.Groups) == 0 {
 			clockMsg = "\nClick ⏰ to add a raid group time."
 		} else {
-			clockMsg = "\nClick 🔢 to join group, ⏰ to add new time."
+			clockMsg = "\nClick 🔢 to join group, ⏰ to add new time, ➕/💙❤️💛 to bring another account, 🏃/🏠/📡/📨 to join in person, as a host, remotely or needing an invite."
 		}
 	}
 	mapUrl := fmt.Sprintf("https://www.google.com/maps/?q=%f,%f",
 		r.Gym.Latitude, r.Gym.Longitude)
 	var groupMsgs []string
-	for n, rg := range r.Groups {
-		groupMsgs = append(groupMsgs, rg.genMessage(n+1))
+	for _, rg := range r.Groups {
+		groupMsgs = append(groupMsgs, rg.genMessage(l))
+	}
+	if r.IsEx() {
+		return fmt.Sprintf("**%s%s** %s - %s\n%s | %s %s%s\n%s",
+			r.Emoji, r.exTitle(), r.HatchTime().Format(r.timeFormat()),
+			r.EndTime.Format("3:04 PM"), r.Gym.Name, r.Gym.StreetAddr, mapUrl, clockMsg,
+			strings.Join(groupMsgs, "\n"))
 	}
 	return fmt.Sprintf("**%s%s** expires %s\n%s | %s %s%s\n%s",
 		r.Emoji, r.What, r.EndTime.Format("3:04 PM"), r.Gym.Name,
@@ -46,43 +154,196 @@ func (r *Raid) GenMessage() string {
 }
 
 func (r *Raid) String() string {
+	if r.IsEx() {
+		return fmt.Sprintf("%s%s at %s %s", r.Emoji, r.exTitle(), r.Gym.Name,
+			r.HatchTime().Format(r.timeFormat()))
+	}
 	return fmt.Sprintf("%s%s raid at %s until %s", r.Emoji, r.What, r.Gym.Name, r.EndTime.Format("3:04 PM"))
 }
 
-func (r *Raid) SendUpdate(s *discordgo.Session) {
-	_, err := s.ChannelMessageEdit(r.ChannelID, r.MessageID, r.GenMessage())
+// everyone in an unexpired group, with their accounts
+func (r *Raid) Members() map[string]int {
+	members := make(map[string]int)
+	for _, rg := range r.Groups {
+		if rg.Expired {
+			continue
+		}
+		for mem, n := range rg.Members {
+			members[mem] += n
+		}
+	}
+	return members
+}
+
+// mark the EX raid reminders already due at t as sent, e.g. when the raid's
+// posted less than a day ahead, so nobody's told it's on "tomorrow"
+func (r *Raid) skipDueReminders(t time.Time) {
+	if !r.IsEx() {
+		return
+	}
+	for _, rem := range exReminders {
+		if !r.reminded(rem.name) && !t.Before(r.HatchTime().Add(-rem.lead)) {
+			r.Reminded = append(r.Reminded, rem.name)
+		}
+	}
+}
+
+// send any EX raid reminders that are due; returns whether any were sent
+func (r *Raid) Remind(n Notifier, t time.Time) bool {
+	if !r.IsEx() || t.After(r.HatchTime()) {
+		return false
+	}
+	sent := false
+	for i, rem := range exReminders {
+		if r.reminded(rem.name) || t.Before(r.HatchTime().Add(-rem.lead)) {
+			continue
+		}
+		r.Reminded = append(r.Reminded, rem.name)
+		sent = true
+		// only the latest reminder due, e.g. after a restart
+		if i+1 < len(exReminders) && !t.Before(r.HatchTime().Add(-exReminders[i+1].lead)) {
+			continue
+		}
+		if members := r.Members(); len(members) > 0 {
+			n.Notify(r.ChannelID, members, fmt.Sprintf("reminder: %s %s!", r.String(), rem.when))
+		}
+	}
+	return sent
+}
+
+func (r *Raid) SendUpdate(s Session, l Limits) {
+	_, err := s.ChannelMessageEdit(r.ChannelID, r.MessageID, r.GenMessage(l))
 	if err != nil {
 		log.Print(err)
 	}
 }
 
-func (r *Raid) AddGroup(startTime time.Time, s *discordgo.Session) *Group {
-	n := len(r.Groups) + 1
+// add a group with its reaction; the post is left for the caller to update
+func (r *Raid) AddGroup(startTime time.Time, s Session) *Group {
+	rg := r.newGroup(startTime)
+	if rg.Number == 1 {
+		r.addGroupReactions(s)
+	}
+	s.MessageReactionAdd(r.ChannelID, r.MessageID, groupEmoji(rg.Number))
+
+	return rg
+}
+
+// the reactions for bringing more accounts and changing how members join,
+// added with the first group
+func (r *Raid) addGroupReactions(s Session) {
+	s.MessageReactionAdd(r.ChannelID, r.MessageID, "➕")
+	s.MessageReactionAdd(r.ChannelID, r.MessageID, "➖")
+	for _, mode := range modes {
+		s.MessageReactionAdd(r.ChannelID, r.MessageID, modeEmoji[mode])
+	}
+}
+
+// add a group with the next number, in start time order
+func (r *Raid) newGroup(startTime time.Time) *Group {
+	r.LastGroup++
 	rg := &Group{
 		raid:      r,
-		number:    n,
+		Number:    r.LastGroup,
 		StartTime: startTime,
 		Members:   make(map[string]int),
 	}
 	r.Groups = append(r.Groups, rg)
-	if n == 1 {
-		s.MessageReactionAdd(r.ChannelID, r.MessageID, "➕")
-		s.MessageReactionAdd(r.ChannelID, r.MessageID, "➖")
+	r.sortGroups()
+	return rg
+}
+
+// why another group can't be added, if it can't
+func (r *Raid) canAddGroup() error {
+	if r.LastGroup >= maxGroups {
+		return fmt.Errorf("the %s has had as many groups as it can (%d)", r.String(), maxGroups)
+	}
+	active := 0
+	for _, rg := range r.Groups {
+		if !rg.Expired {
+			active++
+		}
+	}
+	if active >= maxActiveGroups {
+		return fmt.Errorf("the %s already has %d groups", r.String(), active)
+	}
+	return nil
+}
+
+// group number n, or nil
+func (r *Raid) group(n int) *Group {
+	for _, rg := range r.Groups {
+		if rg.Number == n {
+			return rg
+		}
 	}
-	s.MessageReactionAdd(r.ChannelID, r.MessageID, fmt.Sprintf("%d%s", n, boxEmoji))
-	r.SendUpdate(s)
+	return nil
+}
 
-	return rg
+// keep the groups in start time order, e.g. after one's moved; they keep
+// their numbers, so the reactions for joining them don't change
+func (r *Raid) sortGroups() {
+	sort.SliceStable(r.Groups, func(i, j int) bool {
+		return r.Groups[i].StartTime.Before(r.Groups[j].StartTime)
+	})
+}
+
+// cancel one group, telling its members; the other groups keep their
+// numbers. The post is left for the caller to update.
+func (r *Raid) RemoveGroup(rg *Group, s Session, n Notifier) {
+	if !rg.Expired {
+		rg.Cancel(n)
+		rg.clearReactions(s)
+	}
+	for i, g := range r.Groups {
+		if g == rg {
+			r.Groups = append(r.Groups[:i], r.Groups[i+1:]...)
+			break
+		}
+	}
+	for userID, n := range r.Picked {
+		if n == rg.Number {
+			delete(r.Picked, userID)
+		}
+	}
+}
+
+// the group a user's ➕ and ➖ are for: the unexpired group they joined last
+// if they're still in it, or else the first one they're in; nil if they're
+// in none
+func (r *Raid) extrasGroup(userID string) *Group {
+	in := func(rg *Group) bool {
+		_, ok := rg.Members[userID]
+		return rg != nil && !rg.Expired && (ok || rg.waiter(userID) >= 0)
+	}
+	if rg := r.group(r.Picked[userID]); rg != nil && in(rg) {
+		return rg
+	}
+	for _, rg := range r.Groups {
+		if in(rg) {
+			return rg
+		}
+	}
+	return nil
+}
+
+// remember the group a user joined last
+func (r *Raid) pick(userID string, rg *Group) {
+	if r.Picked == nil {
+		r.Picked = make(map[string]int)
+	}
+	r.Picked[userID] = rg.Number
 }
 
-func (r *Raid) Expire(s *discordgo.Session) {
+// stop taking groups and clear the post's pin and reactions; the post is
+// left for the caller to update
+func (r *Raid) Expire(s Session) {
 	if r.expired {
 		return
 	}
 	r.expired = true
 	log.Printf("%s expired.", r.String())
 	s.ChannelMessageUnpin(r.ChannelID, r.MessageID)
-	r.SendUpdate(s)
 	s.MessageReactionsRemoveAll(r.ChannelID, r.MessageID)
 	s.MessageReactionsRemoveAll(r.ChannelID, r.RequestMsgID)
 }
@@ -93,7 +354,7 @@ func (r *Raid) UpdateGroupPointers() {
 	}
 }
 
-func (r *Raid) OnReactionAdd(bs *BotState, s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+func (r *Raid) OnReactionAdd(bs *BotState, s Session, m *discordgo.MessageReactionAdd) {
 	if m.Emoji.Name == "⏰" {
 		// start private message session to add a time
 	}
@@ -16,6 +16,9 @@ var (
 	ErrNoEnd     = errors.New("no end time specified")
 	ErrNoMatches = errors.New("no matches for gym query")
 	ErrNonUnique = errors.New("too many gyms match query")
+	ErrDisabled  = errors.New("gym is disabled")
+	ErrPast      = errors.New("time is in the past")
+	ErrNoRequest = errors.New("not a raid request")
 )
 
 func expandPokemonAbbr(name string) string {
@@ -141,6 +144,11 @@ func (r *Raid) ParseRaidRequest(req string, gdb *gymdb.GymDB, timebase time.Time
 	}
 	matches, _ := gdb.GetGyms(strings.Join(gymQuery, " "), 1.0)
 	if len(matches) == 0 {
+		// tell them if they're asking for a disabled gym
+		matches, _ = gdb.SearchGyms(strings.Join(gymQuery, " "), 1.0, true)
+		if len(matches) == 1 {
+			return ErrDisabled, matches
+		}
 		return ErrNoMatches, nil
 	}
 	if len(matches) != 1 {
@@ -156,22 +164,126 @@ func (r *Raid) ParseRaidRequest(req string, gdb *gymdb.GymDB, timebase time.Time
 	r.Gym = matches[0]
 	r.What = expandPokemonAbbr(strings.Join(pokemon, " "))
 
+	r.start = time.Time{}
 	if startSpec != nil {
 		startTime, err := parseTimeSpec(startSpec, timebase)
 		if err != nil {
 			return err, nil
 		}
+		// if we're editing, BotState.applyRequest moves the group
+		r.start = startTime
 		if len(r.Groups) == 0 {
-			r.Groups = append(r.Groups, &Group{
-				raid:      r,
-				number:    1,
-				StartTime: startTime,
-				Members:   make(map[string]int),
-			})
-		} else { // if we're editing, just update the start time
-			r.Groups[0].StartTime = startTime
+			r.newGroup(startTime)
+		}
+	}
+
+	return nil, nil
+}
+
+// parse a date like "6/18", "2018-06-18", "jun 18", "tomorrow" or "sat" from
+// the end of spec, returning midnight of the next such date on or after
+// timebase's day and the number of fields used
+func parseDate(spec []string, timebase time.Time) (time.Time, int, error) {
+	if len(spec) == 0 {
+		return time.Time{}, 0, errors.New("empty date")
+	}
+	yy, mm, dd := timebase.Date()
+	today := time.Date(yy, mm, dd, 0, 0, 0, 0, timebase.Location())
+	last := strings.ToLower(spec[len(spec)-1])
+
+	switch last {
+	case "today":
+		return today, 1, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 1, nil
+	}
+	for d := 0; d < 7; d++ {
+		day := today.AddDate(0, 0, d)
+		weekday := strings.ToLower(day.Weekday().String())
+		if len(last) >= 3 && strings.HasPrefix(weekday, last) {
+			return day, 1, nil
+		}
+	}
+
+	// dates without a year are the next one to come
+	nextYear := func(t time.Time) time.Time {
+		if t.Before(today) {
+			return t.AddDate(1, 0, 0)
+		}
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", last, timebase.Location()); err == nil {
+		return t, 1, nil
+	}
+	if t, err := time.ParseInLocation("1/2/2006", last, timebase.Location()); err == nil {
+		return t, 1, nil
+	}
+	if t, err := time.Parse("1/2", last); err == nil {
+		return nextYear(time.Date(yy, t.Month(), t.Day(), 0, 0, 0, 0, timebase.Location())), 1, nil
+	}
+	if len(spec) >= 2 {
+		monthDay := strings.Join(spec[len(spec)-2:], " ")
+		if t, err := time.Parse("Jan 2", strings.Title(strings.ToLower(monthDay))); err == nil {
+			return nextYear(time.Date(yy, t.Month(), t.Day(), 0, 0, 0, 0, timebase.Location())), 2, nil
+		}
+	}
+	return time.Time{}, 0, fmt.Errorf("couldn't understand date %s", last)
+}
+
+// ParseExRaidRequest parses an EX raid invitation: <gym query> <date> <time>
+func (r *Raid) ParseExRaidRequest(req string, gdb *gymdb.GymDB, timebase time.Time) (error, []*gymdb.Gym) {
+	reqSplit := strings.Fields(req)
+	if len(reqSplit) < 3 {
+		return ErrNoEnd, nil
+	}
+
+	// time may be one or two fields ("4:00pm" or "4:00 pm")
+	var clock time.Time
+	var err error
+	fieldEnd := len(reqSplit)
+	for n := 2; n >= 1; n-- {
+		clock, err = fuzzyTime(strings.Join(reqSplit[fieldEnd-n:], " "), timebase)
+		if err == nil {
+			fieldEnd -= n
+			break
+		}
+	}
+	if err != nil {
+		return ErrNoEnd, nil
+	}
+	date, n, err := parseDate(reqSplit[:fieldEnd], timebase)
+	if err != nil {
+		return err, nil
+	}
+	fieldEnd -= n
+	if fieldEnd == 0 {
+		return ErrNoMatches, nil
+	}
+
+	gymQuery := strings.Join(reqSplit[:fieldEnd], " ")
+	matches, _ := gdb.GetGyms(gymQuery, 1.0)
+	if len(matches) == 0 {
+		matches, _ = gdb.SearchGyms(gymQuery, 1.0, true)
+		if len(matches) == 1 {
+			return ErrDisabled, matches
 		}
+		return ErrNoMatches, nil
+	}
+	if len(matches) != 1 {
+		return ErrNonUnique, matches
 	}
 
+	h, m, _ := clock.Clock()
+	hatch := time.Date(date.Year(), date.Month(), date.Day(), h, m, 0, 0, timebase.Location())
+	if hatch.Before(timebase) {
+		return ErrPast, nil
+	}
+	r.Kind = RaidKindEx
+	r.Gym = matches[0]
+	if !r.EndTime.Equal(hatch.Add(RaidDuration)) { // rescheduled
+		r.EndTime = hatch.Add(RaidDuration)
+		r.Hatched = false
+		r.Reminded = nil
+	}
 	return nil, nil
 }
@@ -74,3 +74,36 @@ func TestRaid_ParseRaidRequest(t *testing.T) {
 	}
 	t.Log(r.String())
 }
+
+func TestRaid_ParseExRaidRequest(t *testing.T) {
+	gdb := gymdb.NewGymDB("../gymdb/gyms.txt")
+	t0, err := time.Parse(time.RFC3339, "2018-05-28T15:27:30-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Raid{}
+
+	err, matches := r.ParseExRaidRequest("denker jun 2 4:00pm", gdb, t0)
+	if err != nil {
+		t.Log(matches)
+		t.Fatal(err)
+	}
+	t.Log(r.String())
+	if !r.IsEx() || r.HatchTime().Day() != 2 || r.HatchTime().Hour() != 16 {
+		t.Fatal(r.HatchTime())
+	}
+
+	err, _ = r.ParseExRaidRequest("denker tomorrow 1:00 pm", gdb, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(r.String())
+	if r.HatchTime().Day() != 29 {
+		t.Fatal(r.HatchTime())
+	}
+
+	err, _ = r.ParseExRaidRequest("denker today 1:00 pm", gdb, t0)
+	if err != ErrPast {
+		t.Fatal("expected ErrPast, got", err)
+	}
+}
@@ -0,0 +1,141 @@
+package raid
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reactionLimit is the most reactions discord returns for one emoji
+const reactionLimit = 100
+
+// ReconcileSession is the part of the Discord API needed to check raids
+// against what actually happened while the bot was offline
+type ReconcileSession interface {
+	Session
+	ChannelMessage(channelID, messageID string) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	MessageReactions(channelID, messageID, emojiID string, limit int) ([]*discordgo.User, error)
+}
+
+func notFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// bring every raid up to date with its post and request message, which may
+// have been deleted, reacted to or edited since the snapshot was taken
+func (bs *BotState) reconcile(s ReconcileSession) {
+	for _, raid := range bs.raidList() {
+		bs.reconcileRaid(s, raid)
+	}
+}
+
+func (bs *BotState) reconcileRaid(s ReconcileSession, raid *Raid) {
+	post, err := s.ChannelMessage(raid.ChannelID, raid.MessageID)
+	if notFound(err) {
+		bs.cancelRaid(raid, "raid post is gone")
+		return
+	} else if err != nil {
+		log.Print(err)
+		return
+	}
+
+	var req *discordgo.Message
+	if raid.RequestMsgID != "" {
+		req, err = s.ChannelMessage(raid.ChannelID, raid.RequestMsgID)
+		if notFound(err) {
+			bs.cancelRaid(raid, "raid request is gone")
+			logErr(s.ChannelMessageDelete(raid.ChannelID, raid.MessageID))
+			return
+		} else if err != nil {
+			log.Print(err)
+		}
+	}
+
+	// fetch the reactions of each group outside the lock, by group number;
+	// missing means unknown
+	raid.mut.Lock()
+	var active []int
+	for _, rg := range raid.Groups {
+		if !rg.Expired {
+			active = append(active, rg.Number)
+		}
+	}
+	raid.mut.Unlock()
+	joined := make(map[int]map[string]bool)
+	for _, n := range active {
+		users, err := s.MessageReactions(raid.ChannelID, raid.MessageID, groupEmoji(n), reactionLimit)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		joined[n] = make(map[string]bool)
+		for _, u := range users {
+			if post.Author == nil || u.ID != post.Author.ID {
+				joined[n][u.ID] = true
+			}
+		}
+	}
+
+	out := &deferred{}
+	raid.mut.Lock()
+	for n, users := range joined {
+		rg := raid.group(n)
+		if rg == nil || rg.Expired {
+			continue
+		}
+		var left []string
+		for id := range rg.Members {
+			left = append(left, id)
+		}
+		for _, w := range rg.Waitlist {
+			left = append(left, w.UserID)
+		}
+		for _, id := range left {
+			if !users[id] {
+				log.Printf("%s left raidgroup %s while we were away", id, rg.String())
+				rg.leave(id)
+			}
+		}
+		bs.promoteWaitlist(out, rg)
+		for id := range users {
+			if _, ok := rg.Members[id]; !ok && rg.waiter(id) < 0 {
+				log.Printf("%s joined raidgroup %s while we were away", id, rg.String())
+				rg.join(id, bs.userSettings(id).Team, bs.limits(raid.GuildID).GroupCap)
+			}
+		}
+	}
+	if req != nil {
+		if raid.Request == "" {
+			// from a snapshot older than raid.Request; assume it's current
+			raid.Request = req.Content
+		} else if req.Content != raid.Request {
+			log.Printf("raid request for %s was edited", raid.String())
+			t, err := req.EditedTimestamp.Parse()
+			if err != nil {
+				t = bs.clock.Now()
+			}
+			if err := bs.applyRequest(out, raid, req.Content, t); err != nil {
+				log.Printf("can't understand raid request: %s", err)
+			}
+		}
+	}
+	bs.updatePost(out, raid)
+	bs.recordRaid(raid)
+	raid.mut.Unlock()
+	out.flush(bs.outbox)
+}
+
+// let everyone know a raid is off and stop tracking it, logging why
+func (bs *BotState) cancelRaid(raid *Raid, why string) {
+	bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+		log.Printf("Deleting raid %s: %s", raid.String(), why)
+		for _, rg := range raid.Groups {
+			if !rg.Expired {
+				rg.Cancel(bs.notifier(out))
+			}
+		}
+	})
+	bs.removeRaid(raid)
+}
@@ -0,0 +1,145 @@
+package raid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// minutes before a group starts to remind its members, unless the guild set
+// its own with !reminders leads
+var DefaultReminderLeads = []int{10, 2}
+
+// per-user settings, saved in the snapshot
+type UserSettings struct {
+	Notify     string `json:"reminders,omitempty"`   // NotifyMention, NotifyDM or NotifyOff; tagged "reminders" so settings saved by !reminders still load
+	QuietStart string `json:"quiet_start,omitempty"` // "22:00"; no notifications from then
+	QuietEnd   string `json:"quiet_end,omitempty"`   // until then
+	Team       string `json:"team,omitempty"`
+	Profile
+}
+
+func (bs *BotState) userSettings(userID string) UserSettings {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	if u, ok := bs.Users[userID]; ok {
+		return *u
+	}
+	return UserSettings{}
+}
+
+func (bs *BotState) setUserSettings(userID string, u UserSettings) {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	if u == (UserSettings{}) {
+		delete(bs.Users, userID)
+	} else {
+		bs.Users[userID] = &u
+	}
+	bs.recordSettings()
+}
+
+// the minutes before a group starts to remind its members, for a guild's
+// raids. Leads set before they were per guild are under "", and still go
+// for guilds that haven't set their own.
+func (bs *BotState) reminderLeads(guildID string) []int {
+	bs.mut.Lock()
+	defer bs.mut.Unlock()
+	if leads, ok := bs.ReminderLeads[guildID]; ok {
+		return leads
+	}
+	if leads, ok := bs.ReminderLeads[""]; ok {
+		return leads
+	}
+	return DefaultReminderLeads
+}
+
+// remind the members of a group that it's starting soon, each the way they
+// asked to be told; must hold the raid's lock
+func (bs *BotState) sendGroupReminder(out *deferred, rg *Group, t time.Time) {
+	bs.notify(out, rg.raid.ChannelID, rg.Members, fmt.Sprintf("reminder: group %d for the %s starts in %d minutes (%s)",
+		rg.Number, rg.raid.String(), int(rg.StartTime.Sub(t).Minutes()+0.5), rg.StartTime.Format("3:04 PM")))
+}
+
+func (bs *BotState) remindersCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !reminders - show settings
+	//  - !reminders mention|dm|off - same as !notify
+	//  - !reminders leads <minutes...>|none - when to send the server's reminders
+	args := strings.Fields(strings.ToLower(query))
+	guildID := channelGuild(s, m.ChannelID)
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> I remind you %s, %s before your raid groups start",
+			m.Author.ID, notifyHow(bs.userSettings(m.Author.ID).Notify), formatLeads(bs.reminderLeads(guildID))))
+		return
+	}
+
+	if _, ok := parseNotify(args[0]); ok {
+		bs.notifyCommand(s, m, query)
+		return
+	}
+	switch args[0] {
+	case "leads":
+		usage := "<@" + m.Author.ID + "> reminder lead times are minutes, like `!reminders leads 10 2`, or `none` to turn group reminders off"
+		if len(args) == 1 {
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+		if guildID == "" {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> set when reminders are sent from a channel on your server")
+			return
+		}
+		if !isModerator(s, m.Author.ID, m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> only moderators can change when reminders are sent")
+			return
+		}
+		leads := []int{}
+		if len(args) != 2 || args[1] != "none" {
+			for _, arg := range args[1:] {
+				n, err := strconv.Atoi(strings.TrimSuffix(arg, "m"))
+				if err != nil || n <= 0 {
+					s.ChannelMessageSend(m.ChannelID, usage)
+					return
+				}
+				leads = append(leads, n)
+			}
+		}
+		bs.mut.Lock()
+		if bs.ReminderLeads == nil {
+			bs.ReminderLeads = make(map[string][]int)
+		}
+		bs.ReminderLeads[guildID] = leads
+		bs.recordSettings()
+		bs.mut.Unlock()
+		for _, raid := range bs.raidList() {
+			if raid.GuildID != guildID {
+				continue
+			}
+			raid.mut.Lock()
+			bs.scheduleRaid(raid)
+			raid.mut.Unlock()
+		}
+		if len(leads) == 0 {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> Group reminders are off")
+			return
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Reminding groups %s before they start",
+			m.Author.ID, formatLeads(leads)))
+	default:
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!notify [mention|dm|off]` or `!reminders leads <minutes...>`")
+	}
+}
+
+func formatLeads(leads []int) string {
+	if len(leads) == 0 {
+		return "no time"
+	}
+	var mins []string
+	for _, n := range leads {
+		mins = append(mins, strconv.Itoa(n))
+	}
+	return strings.Join(mins, " and ") + " minutes"
+}
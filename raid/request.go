@@ -2,9 +2,9 @@ package raid
 
 import (
 	"github.com/bwmarrin/discordgo"
-	"time"
 	"log"
 	"strings"
+	"time"
 )
 
 // proxy type for the original raid request message; editing it can update the raid
@@ -12,33 +12,57 @@ type Request struct {
 	Raid *Raid
 }
 
-func (r *Request) OnReactionAdd(bs *BotState, s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+func (r *Request) OnReactionAdd(bs *BotState, s Session, m *discordgo.MessageReactionAdd) {
 	// no-op
 }
 
-func (r *Request) OnReactionRemove(bs *BotState, s *discordgo.Session, m *discordgo.MessageReactionRemove) {
+func (r *Request) OnReactionRemove(bs *BotState, s Session, m *discordgo.MessageReactionRemove) {
 	// no-op
 }
 
 func (r *Request) OnMessageEdit(bs *BotState, s *discordgo.Session, m *discordgo.MessageUpdate) {
+	out := &deferred{}
+	r.Raid.mut.Lock()
 	log.Printf("editing raid %s", r.Raid.String())
-	splitMsg := strings.SplitN(m.Content[len(commandLeader):], " ", 2)
-	err, _ := r.Raid.ParseRaidRequest(splitMsg[1], bs.gymdb, time.Now())
-	if err == nil {
-		r.Raid.SendUpdate(s)
-	} else {
+	if err := bs.applyRequest(out, r.Raid, m.Content, bs.clock.Now()); err != nil {
 		log.Printf("can't understand raid request: %s", err)
 	}
+	r.Raid.mut.Unlock()
+	out.flush(bs.outbox)
 }
 
-func (r *Request) OnMessageDelete(bs *BotState, s *discordgo.Session, m *discordgo.MessageDelete) {
-	log.Printf("Deleting raid %s", r.Raid.String())
-	for _, rg := range r.Raid.Groups {
-		rg.Cancel(s)
+// re-parse an edited request message, with relative times counted from t,
+// and update the raid post; must hold raid.mut
+func (bs *BotState) applyRequest(out *deferred, raid *Raid, content string, t time.Time) error {
+	if !strings.HasPrefix(content, commandLeader) {
+		return ErrNoRequest
+	}
+	splitMsg := strings.SplitN(content[len(commandLeader):], " ", 2)
+	if len(splitMsg) < 2 {
+		return ErrNoRequest
+	}
+	var err error
+	if raid.IsEx() {
+		err, _ = raid.ParseExRaidRequest(splitMsg[1], bs.gymdb, t)
+	} else {
+		err, _ = raid.ParseRaidRequest(splitMsg[1], bs.gymdb, t)
+	}
+	if err != nil {
+		return err
+	}
+	raid.Request = content
+	// a new start time moves the first group, unless it's already started
+	if rg := raid.group(1); rg != nil && !rg.Expired && !raid.start.IsZero() && !raid.start.Equal(rg.StartTime) {
+		bs.moveGroup(out, rg, raid.start)
 	}
+	raid.skipDueReminders(t)
+	bs.updatePost(out, raid)
+	bs.scheduleRaid(raid)
+	bs.recordRaid(raid)
+	return nil
+}
+
+func (r *Request) OnMessageDelete(bs *BotState, s *discordgo.Session, m *discordgo.MessageDelete) {
+	bs.cancelRaid(r.Raid, "raid request deleted")
 	s.ChannelMessageDelete(r.Raid.ChannelID, r.Raid.MessageID)
-	bs.mut.Lock()
-	defer bs.mut.Unlock()
-	delete(bs.Raids, m.Message.ID)
-	bs.dirty = true
 }
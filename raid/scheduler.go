@@ -0,0 +1,240 @@
+package raid
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type EventKind int
+
+const (
+	EventHatch        EventKind = iota // raid egg hatches
+	EventGroupStart                    // raid group starts
+	EventReminder                      // group or EX raid reminder is due
+	EventRaidEnd                       // raid is over
+	EventSave                          // snapshot the state if it changed
+	EventOutcomeClose                  // stop taking outcome reports; RaidID is the report message id
+	EventInvites                       // put a group's hosts and remote players in touch
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventHatch:
+		return "hatch"
+	case EventGroupStart:
+		return "group-start"
+	case EventReminder:
+		return "reminder"
+	case EventRaidEnd:
+		return "raid-end"
+	case EventSave:
+		return "save"
+	case EventOutcomeClose:
+		return "outcome-close"
+	case EventInvites:
+		return "invites"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is something due to happen to a raid (or the bot) at a given time
+type Event struct {
+	When   time.Time
+	Kind   EventKind
+	RaidID string // raid message id
+	Group  int    // group number for EventGroupStart and group reminders, else -1
+	Lead   int    // minutes before the group start or hatch, for EventReminder
+
+	index int // position in the heap
+}
+
+// events are unique by everything but their time; scheduling the same event
+// again moves it
+type eventKey struct {
+	Kind   EventKind
+	RaidID string
+	Group  int
+	Lead   int
+}
+
+func (e *Event) key() eventKey {
+	return eventKey{e.Kind, e.RaidID, e.Group, e.Lead}
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s/%d/%d at %s", e.Kind, e.RaidID, e.Group, e.Lead, e.When.Format(time.RFC3339))
+}
+
+// min-heap of events by time
+type eventHeap []*Event
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].When.Equal(h[j].When) {
+		return h[i].Kind < h[j].Kind // e.g. groups start before the raid ends
+	}
+	return h[i].When.Before(h[j].When)
+}
+
+func (h eventHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *eventHeap) Push(x interface{}) {
+	e := x.(*Event)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// Scheduler calls a handler for each event when it falls due, sleeping until
+// the next one rather than polling
+type Scheduler struct {
+	clock   Clock
+	handler func(Event)
+
+	mut    sync.Mutex
+	events eventHeap
+	keys   map[eventKey]*Event
+	wake   chan struct{}
+	stop   chan struct{}
+}
+
+func NewScheduler(clock Clock, handler func(Event)) *Scheduler {
+	return &Scheduler{
+		clock:   clock,
+		handler: handler,
+		keys:    make(map[eventKey]*Event),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Schedule adds an event, or moves it if it's already scheduled
+func (sc *Scheduler) Schedule(e Event) {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	if old, ok := sc.keys[e.key()]; ok {
+		old.When = e.When
+		heap.Fix(&sc.events, old.index)
+	} else {
+		ev := e
+		heap.Push(&sc.events, &ev)
+		sc.keys[ev.key()] = &ev
+	}
+	sc.poke()
+}
+
+// CancelRaid drops every event for a raid
+func (sc *Scheduler) CancelRaid(raidID string) {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	for k, e := range sc.keys {
+		if k.RaidID == raidID {
+			heap.Remove(&sc.events, e.index)
+			delete(sc.keys, k)
+		}
+	}
+	sc.poke()
+}
+
+// Pending returns the scheduled events in the order they'll fire
+func (sc *Scheduler) Pending() []Event {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	h := make(eventHeap, len(sc.events))
+	for i, e := range sc.events {
+		ev := *e
+		h[i] = &ev
+	}
+	var events []Event
+	for h.Len() > 0 {
+		events = append(events, *heap.Pop(&h).(*Event))
+	}
+	return events
+}
+
+// wake up the run loop to look at the new first event
+func (sc *Scheduler) poke() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop the first event if it's due at t
+func (sc *Scheduler) popDue(t time.Time) (Event, bool) {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	if len(sc.events) == 0 || sc.events[0].When.After(t) {
+		return Event{}, false
+	}
+	e := heap.Pop(&sc.events).(*Event)
+	delete(sc.keys, e.key())
+	return *e, true
+}
+
+// RunDue handles every event due at t, including any the handlers schedule
+// that are already due
+func (sc *Scheduler) RunDue(t time.Time) {
+	for {
+		e, ok := sc.popDue(t)
+		if !ok {
+			return
+		}
+		sc.handler(e)
+	}
+}
+
+// time until the first event, if any
+func (sc *Scheduler) next() (time.Duration, bool) {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	if len(sc.events) == 0 {
+		return 0, false
+	}
+	return sc.events[0].When.Sub(sc.clock.Now()), true
+}
+
+// Run handles events as they fall due until Stop is called
+func (sc *Scheduler) Run() {
+	for {
+		sc.RunDue(sc.clock.Now())
+		var timer Timer
+		var timeout <-chan time.Time
+		if d, ok := sc.next(); ok {
+			timer = sc.clock.NewTimer(d)
+			timeout = timer.C()
+		}
+		select {
+		case <-sc.stop:
+		case <-sc.wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-sc.stop:
+			return
+		default:
+		}
+	}
+}
+
+func (sc *Scheduler) Stop() {
+	close(sc.stop)
+}
@@ -0,0 +1,38 @@
+package raid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	t0, _ := time.Parse(time.RFC3339, "2018-05-22T20:00:00-07:00")
+	var fired []Event
+	sc := NewScheduler(realClock{}, func(e Event) {
+		fired = append(fired, e)
+	})
+
+	sc.Schedule(Event{When: t0.Add(45 * time.Minute), Kind: EventRaidEnd, RaidID: "a", Group: -1})
+	sc.Schedule(Event{When: t0.Add(20 * time.Minute), Kind: EventGroupStart, RaidID: "a", Group: 0})
+	sc.Schedule(Event{When: t0.Add(10 * time.Minute), Kind: EventReminder, RaidID: "a", Group: 0, Lead: 10})
+	sc.Schedule(Event{When: t0.Add(30 * time.Minute), Kind: EventRaidEnd, RaidID: "b", Group: -1})
+	// moving the group start reschedules rather than adding another event
+	sc.Schedule(Event{When: t0.Add(5 * time.Minute), Kind: EventGroupStart, RaidID: "a", Group: 0})
+	if n := len(sc.Pending()); n != 4 {
+		t.Fatal(sc.Pending())
+	}
+
+	sc.RunDue(t0.Add(10 * time.Minute))
+	if len(fired) != 2 || fired[0].Kind != EventGroupStart || fired[1].Kind != EventReminder {
+		t.Fatal(fired)
+	}
+
+	sc.CancelRaid("a")
+	sc.RunDue(t0.Add(time.Hour))
+	if len(fired) != 3 || fired[2].RaidID != "b" {
+		t.Fatal(fired)
+	}
+	if len(sc.Pending()) != 0 {
+		t.Fatal(sc.Pending())
+	}
+}
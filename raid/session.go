@@ -0,0 +1,92 @@
+package raid
+
+import (
+	"errors"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is the part of the Discord API raids use outside of event
+// handlers, so they can run against a fake in tests
+type Session interface {
+	ChannelMessageSend(channelID, content string) (*discordgo.Message, error)
+	ChannelMessageEdit(channelID, messageID, content string) (*discordgo.Message, error)
+	ChannelMessageUnpin(channelID, messageID string) error
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string) error
+	MessageReactionsRemoveAll(channelID, messageID string) error
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+}
+
+// deferred collects the discord calls made while holding a lock, to send
+// once it's released; calls return nothing useful until then
+type deferred struct {
+	calls []func(Session)
+}
+
+func (d *deferred) do(f func(Session)) {
+	d.calls = append(d.calls, f)
+}
+
+// make the calls collected so far
+func (d *deferred) flush(s Session) {
+	for _, f := range d.calls {
+		f(s)
+	}
+	d.calls = nil
+}
+
+func logErr(err error) {
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (d *deferred) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	d.do(func(s Session) {
+		_, err := s.ChannelMessageSend(channelID, content)
+		logErr(err)
+	})
+	return nil, nil
+}
+
+func (d *deferred) ChannelMessageEdit(channelID, messageID, content string) (*discordgo.Message, error) {
+	d.do(func(s Session) {
+		_, err := s.ChannelMessageEdit(channelID, messageID, content)
+		logErr(err)
+	})
+	return nil, nil
+}
+
+func (d *deferred) ChannelMessageUnpin(channelID, messageID string) error {
+	d.do(func(s Session) {
+		logErr(s.ChannelMessageUnpin(channelID, messageID))
+	})
+	return nil
+}
+
+func (d *deferred) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	d.do(func(s Session) {
+		logErr(s.MessageReactionAdd(channelID, messageID, emojiID))
+	})
+	return nil
+}
+
+func (d *deferred) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	d.do(func(s Session) {
+		logErr(s.MessageReactionRemove(channelID, messageID, emojiID, userID))
+	})
+	return nil
+}
+
+func (d *deferred) MessageReactionsRemoveAll(channelID, messageID string) error {
+	d.do(func(s Session) {
+		logErr(s.MessageReactionsRemoveAll(channelID, messageID))
+	})
+	return nil
+}
+
+func (d *deferred) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	return nil, errors.New("can't open a DM channel until the lock is released")
+}
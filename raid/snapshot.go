@@ -0,0 +1,110 @@
+package raid
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"raidquaza/store"
+)
+
+// rqdata.json: the state in a versioned envelope
+var snapshotSchema = store.NewSchema("rqdata", 5)
+
+func init() {
+	// v1: the bare state, from before snapshots were versioned
+	snapshotSchema.Register(1, func(data []byte) ([]byte, error) {
+		return data, nil
+	})
+	// v2: groups known by their place in the raid, from before they kept
+	// their numbers
+	snapshotSchema.Register(2, numberGroups)
+	// v3: one list of reminder leads for every guild
+	snapshotSchema.Register(3, guildReminderLeads)
+	// v4: one remote cap for every guild
+	snapshotSchema.Register(4, guildRemoteCaps)
+}
+
+// number each raid's groups in order, leaving every other field as it was
+func numberGroups(data []byte) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	var raids map[string]map[string]json.RawMessage
+	if len(state["raids"]) > 0 {
+		if err := json.Unmarshal(state["raids"], &raids); err != nil {
+			return nil, err
+		}
+	}
+	for _, raid := range raids {
+		var groups []map[string]json.RawMessage
+		if len(raid["groups"]) > 0 {
+			if err := json.Unmarshal(raid["groups"], &groups); err != nil {
+				return nil, err
+			}
+		}
+		for i, group := range groups {
+			group["number"] = json.RawMessage(strconv.Itoa(i + 1))
+		}
+		var err error
+		if raid["groups"], err = json.Marshal(groups); err != nil {
+			return nil, err
+		}
+		raid["last_group"] = json.RawMessage(strconv.Itoa(len(groups)))
+	}
+	if raids != nil {
+		var err error
+		if state["raids"], err = json.Marshal(raids); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(state)
+}
+
+// keep reminder leads set for every guild under "", where they still go for
+// guilds without their own
+func guildReminderLeads(data []byte) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	var leads []int
+	if len(state["reminder_leads"]) > 0 {
+		if err := json.Unmarshal(state["reminder_leads"], &leads); err != nil {
+			return nil, err
+		}
+	}
+	delete(state, "reminder_leads")
+	if leads != nil {
+		m, err := json.Marshal(map[string][]int{"": leads})
+		if err != nil {
+			return nil, err
+		}
+		state["reminder_leads"] = m
+	}
+	return json.Marshal(state)
+}
+
+// keep a remote cap set for every guild under "", where it still goes for
+// guilds without their own
+func guildRemoteCaps(data []byte) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	var n *int
+	if len(state["remote_cap"]) > 0 {
+		if err := json.Unmarshal(state["remote_cap"], &n); err != nil {
+			return nil, err
+		}
+	}
+	delete(state, "remote_cap")
+	if n != nil {
+		m, err := json.Marshal(map[string]int{"": *n})
+		if err != nil {
+			return nil, err
+		}
+		state["remote_caps"] = m
+	}
+	return json.Marshal(state)
+}
@@ -0,0 +1,93 @@
+package raid
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"raidquaza/store"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// each historical snapshot version loads and saves as the current version
+func TestSnapshotSchema(t *testing.T) {
+	golden := fmt.Sprintf("testdata/rqdata_v%d.json", snapshotSchema.Version)
+	for v := 1; v <= snapshotSchema.Version; v++ {
+		orig, err := ioutil.ReadFile(fmt.Sprintf("testdata/rqdata_v%d.json", v))
+		if err != nil {
+			if *update && v == snapshotSchema.Version {
+				continue
+			}
+			t.Fatal(err)
+		}
+		dir, cleanup := tempDir(t)
+		path := filepath.Join(dir, "rqdata.json")
+		ioutil.WriteFile(path, orig, 0644)
+
+		t0, _ := time.Parse(time.RFC3339, "2018-05-28T15:27:30-07:00")
+		bs := newBotState(&fakeSession{}, NewFakeClock(t0), path, "../gymdb/gyms.txt")
+		if len(bs.Raids) == 0 {
+			t.Fatalf("v%d: no raids loaded", v)
+		}
+		if err := bs.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		bs.Stop()
+		got, _ := ioutil.ReadFile(path)
+		if *update && v == 1 {
+			ioutil.WriteFile(golden, got, 0644)
+		}
+		want, _ := ioutil.ReadFile(golden)
+		if string(got) != string(want) {
+			t.Errorf("v%d saved as\n%s\nwant\n%s", v, got, want)
+		}
+
+		bak, err := ioutil.ReadFile(store.BackupPath(path, v))
+		if v < snapshotSchema.Version && string(bak) != string(orig) {
+			t.Errorf("v%d: no backup of the original: %v", v, err)
+		} else if v == snapshotSchema.Version && err == nil {
+			t.Errorf("v%d: backed up the current version", v)
+		}
+		cleanup()
+	}
+}
+
+// a journal left by an older version, e.g. after a crash, is upgraded too
+func TestJournalSchema(t *testing.T) {
+	golden := "testdata/rqdata_v2_journal.json"
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "rqdata.json")
+	for from, to := range map[string]string{
+		"testdata/rqdata_v2.json":    path,
+		"testdata/rqdata_v2.journal": path + journalSuffix,
+	} {
+		orig, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(to, orig, 0644)
+	}
+
+	t0, _ := time.Parse(time.RFC3339, "2018-05-28T15:27:30-07:00")
+	bs := newBotState(&fakeSession{}, NewFakeClock(t0), path, "../gymdb/gyms.txt")
+	if len(bs.Raids) != 2 || bs.Raids["449310226455232512"].group(3) == nil {
+		t.Fatal("journaled raids not loaded", bs.Raids)
+	}
+	if err := bs.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	bs.Stop()
+	got, _ := ioutil.ReadFile(path)
+	if *update {
+		ioutil.WriteFile(golden, got, 0644)
+	}
+	want, _ := ioutil.ReadFile(golden)
+	if string(got) != string(want) {
+		t.Errorf("saved as\n%s\nwant\n%s", got, want)
+	}
+}
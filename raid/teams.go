@@ -0,0 +1,286 @@
+package raid
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// teams
+const (
+	TeamNone     = ""
+	TeamMystic   = "mystic"
+	TeamValor    = "valor"
+	TeamInstinct = "instinct"
+)
+
+// the order teams are shown in
+var teams = []string{TeamMystic, TeamValor, TeamInstinct}
+
+// reacting with a team's heart brings an extra of that team
+var teamHearts = map[string]string{
+	TeamMystic:   "💙",
+	TeamValor:    "❤\ufe0f",
+	TeamInstinct: "💛",
+}
+
+// the team a heart reaction is for, if it is one; discord may leave the
+// variation selector off ❤️
+func heartTeam(emoji string) (string, bool) {
+	emoji = strings.TrimSuffix(emoji, "\ufe0f")
+	for team, heart := range teamHearts {
+		if emoji == strings.TrimSuffix(heart, "\ufe0f") {
+			return team, true
+		}
+	}
+	return TeamNone, false
+}
+
+// parse a team by name, color or initial
+func parseTeam(s string) (string, bool) {
+	switch strings.ToLower(s) {
+	case TeamMystic, "blue", "m":
+		return TeamMystic, true
+	case TeamValor, "red", "v":
+		return TeamValor, true
+	case TeamInstinct, "yellow", "i":
+		return TeamInstinct, true
+	case "none":
+		return TeamNone, true
+	}
+	return TeamNone, false
+}
+
+// the team's icon: the guild's emoji named after it if there is one, or its
+// heart
+func teamIcon(team string) string {
+	if team == TeamNone {
+		return "❔"
+	}
+	emojiMut.RLock()
+	id, ok := globalEmojiMap[team]
+	emojiMut.RUnlock()
+	if ok {
+		return "<:" + team + ":" + id + ">"
+	}
+	return teamHearts[team]
+}
+
+// add a member, who's on team, or put them on the waitlist if the group's
+// full, taking guildCap accounts unless it has its own cap; returns false if
+// they're waiting
+func (rg *Group) join(userID, team string, guildCap int) bool {
+	if _, ok := rg.Members[userID]; ok {
+		return true
+	}
+	if rg.waiter(userID) >= 0 {
+		return false
+	}
+	if rg.full(guildCap) {
+		rg.wait(userID, team)
+		return false
+	}
+	rg.Members[userID] = 1
+	rg.setTeam(userID, team)
+	return true
+}
+
+// remove a member or waiting user; the caller fills the freed spots with
+// promoteWaitlist
+func (rg *Group) leave(userID string) {
+	rg.unwait(userID)
+	delete(rg.Members, userID)
+	delete(rg.Teams, userID)
+	delete(rg.Extras, userID)
+	delete(rg.Modes, userID)
+}
+
+func (rg *Group) setTeam(userID, team string) {
+	if team == TeamNone {
+		delete(rg.Teams, userID)
+		return
+	}
+	if rg.Teams == nil {
+		rg.Teams = make(map[string]string)
+	}
+	rg.Teams[userID] = team
+}
+
+// the teams of a member's +N extras, TeamNone where it isn't known
+func (rg *Group) extraTeams(userID string) []string {
+	n := rg.Members[userID] - 1
+	if n <= 0 {
+		return nil
+	}
+	extras := make([]string, n)
+	copy(extras, rg.Extras[userID])
+	return extras
+}
+
+func (rg *Group) setExtras(userID string, extras []string) {
+	rg.Members[userID] = len(extras) + 1
+	known := false
+	for _, team := range extras {
+		known = known || team != TeamNone
+	}
+	if !known {
+		delete(rg.Extras, userID)
+		return
+	}
+	if rg.Extras == nil {
+		rg.Extras = make(map[string][]string)
+	}
+	rg.Extras[userID] = extras
+}
+
+// a member brings another account, on team, which waits if the group's
+// full, as it does if they're waiting themselves; returns false if they're
+// not in the group or on its waitlist
+func (rg *Group) addExtra(userID, team string, guildCap int) bool {
+	if rg.waiter(userID) >= 0 {
+		rg.wait(userID, team)
+		return true
+	}
+	if _, ok := rg.Members[userID]; !ok {
+		return false
+	}
+	if rg.full(guildCap) {
+		rg.wait(userID, team)
+		return true
+	}
+	rg.setExtras(userID, append(rg.extraTeams(userID), team))
+	return true
+}
+
+// a member brings one fewer account, the last one they added, waiting ones
+// first; returns false if they had no extras. The caller fills a freed spot
+// with promoteWaitlist.
+func (rg *Group) removeExtra(userID string) bool {
+	if rg.dropWaiting(userID) {
+		return true
+	}
+	extras := rg.extraTeams(userID)
+	if len(extras) == 0 {
+		return false
+	}
+	rg.setExtras(userID, extras[:len(extras)-1])
+	return true
+}
+
+// accounts in the group on each team, extras included
+func (rg *Group) TeamCounts() map[string]int {
+	counts := make(map[string]int)
+	for userID := range rg.Members {
+		counts[rg.Teams[userID]]++
+		for _, team := range rg.extraTeams(userID) {
+			counts[team]++
+		}
+	}
+	return counts
+}
+
+// per-team counts like "💙2 ❤️1 ❔1", or nothing if no teams are known
+func (rg *Group) teamSummary() string {
+	counts := rg.TeamCounts()
+	if counts[TeamNone] == rg.Total() {
+		return ""
+	}
+	var parts []string
+	for _, team := range append(teams, TeamNone) {
+		if counts[team] > 0 {
+			parts = append(parts, fmt.Sprintf("%s%d", teamIcon(team), counts[team]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// the team from a member's Mystic, Valor or Instinct role
+func teamFromRoles(s *discordgo.Session, guildID, userID string) (string, bool) {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil || member == nil {
+		member, err = s.GuildMember(guildID, userID)
+		if err != nil {
+			log.Print(err)
+			return TeamNone, false
+		}
+	}
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		log.Print(err)
+		return TeamNone, false
+	}
+	for _, role := range roles {
+		// only the team names, so colour roles like "Red" don't count
+		team := strings.ToLower(strings.TrimSpace(role.Name))
+		if team != TeamMystic && team != TeamValor && team != TeamInstinct {
+			continue
+		}
+		for _, id := range member.Roles {
+			if id == role.ID {
+				return team, true
+			}
+		}
+	}
+	return TeamNone, false
+}
+
+func (bs *BotState) teamCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !team <mystic|valor|instinct|none>
+	//  - !team (take it from your team role)
+	query = strings.TrimSpace(query)
+	var team string
+	if query == "" {
+		ch, err := s.State.Channel(m.ChannelID)
+		if err != nil || ch == nil || ch.GuildID == "" {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!team <mystic|valor|instinct|none>`")
+			return
+		}
+		var ok bool
+		team, ok = teamFromRoles(s, ch.GuildID, m.Author.ID)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> you don't have a team role; use `!team <mystic|valor|instinct|none>`")
+			return
+		}
+	} else {
+		var ok bool
+		team, ok = parseTeam(query)
+		if !ok {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> use `!team <mystic|valor|instinct|none>`")
+			return
+		}
+	}
+
+	bs.setTeam(m.Author.ID, team)
+	if team == TeamNone {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> ok, cleared your team")
+	} else {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> ok, you're on team %s %s",
+			m.Author.ID, strings.Title(team), teamIcon(team)))
+	}
+}
+
+// set a user's team, and show it in the groups they've already joined
+func (bs *BotState) setTeam(userID, team string) {
+	u := bs.userSettings(userID)
+	u.Team = team
+	bs.setUserSettings(userID, u)
+
+	for _, raid := range bs.raidList() {
+		bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+			changed := false
+			for _, rg := range raid.Groups {
+				if _, ok := rg.Members[userID]; ok && !rg.Expired && rg.Teams[userID] != team {
+					rg.setTeam(userID, team)
+					changed = true
+				}
+			}
+			if changed {
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package raid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how many accounts a group takes, unless its guild's set another with
+// !groupcap; a raid lobby holds 20
+const DefaultGroupCap = 20
+
+// a user's accounts waiting for spots in a full group
+type Waiter struct {
+	UserID   string   `json:"user"`
+	Accounts []string `json:"accounts"` // team of each waiting account, TeamNone where it isn't known
+}
+
+// how many accounts the group takes: its own cap, or else its guild's
+func (rg *Group) capacity(guildCap int) int {
+	if rg.Cap > 0 {
+		return rg.Cap
+	}
+	return guildCap
+}
+
+func (rg *Group) full(guildCap int) bool {
+	return rg.Total() >= rg.capacity(guildCap)
+}
+
+// where a user is on the waitlist, or -1
+func (rg *Group) waiter(userID string) int {
+	for i, w := range rg.Waitlist {
+		if w.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// put an account on the end of the waitlist, with the user's other waiting
+// accounts if they have any; returns their place in line
+func (rg *Group) wait(userID, team string) int {
+	if i := rg.waiter(userID); i >= 0 {
+		rg.Waitlist[i].Accounts = append(rg.Waitlist[i].Accounts, team)
+		return i + 1
+	}
+	rg.Waitlist = append(rg.Waitlist, &Waiter{userID, []string{team}})
+	return len(rg.Waitlist)
+}
+
+func (rg *Group) unwait(userID string) {
+	if i := rg.waiter(userID); i >= 0 {
+		rg.Waitlist = append(rg.Waitlist[:i], rg.Waitlist[i+1:]...)
+	}
+	if len(rg.Waitlist) == 0 {
+		rg.Waitlist = nil
+	}
+}
+
+// drop a user's last waiting account, but not the one they're waiting to
+// join with; returns false if they have none to drop
+func (rg *Group) dropWaiting(userID string) bool {
+	i := rg.waiter(userID)
+	if i < 0 {
+		return false
+	}
+	w := rg.Waitlist[i]
+	_, member := rg.Members[userID]
+	if !member && len(w.Accounts) == 1 {
+		return false
+	}
+	w.Accounts = w.Accounts[:len(w.Accounts)-1]
+	if len(w.Accounts) == 0 {
+		rg.unwait(userID)
+	}
+	return true
+}
+
+// let waiting accounts into the group, first come first served, while there's
+// room; returns the users who got in
+func (rg *Group) promote(guildCap int) []string {
+	var promoted []string
+	for len(rg.Waitlist) > 0 && !rg.full(guildCap) {
+		w := rg.Waitlist[0]
+		team := w.Accounts[0]
+		if _, ok := rg.Members[w.UserID]; ok {
+			rg.setExtras(w.UserID, append(rg.extraTeams(w.UserID), team))
+		} else {
+			rg.Members[w.UserID] = 1
+			rg.setTeam(w.UserID, team)
+		}
+		if len(promoted) == 0 || promoted[len(promoted)-1] != w.UserID {
+			promoted = append(promoted, w.UserID)
+		}
+		w.Accounts = w.Accounts[1:]
+		if len(w.Accounts) == 0 {
+			rg.unwait(w.UserID)
+		}
+	}
+	return promoted
+}
+
+// the waitlist in order, like "<@a> <@b> (x2) <@c> (+1)"; a member's waiting
+// extras are shown as +N
+func (rg *Group) waitlistMentions() string {
+	var mentions []string
+	for _, w := range rg.Waitlist {
+		mention := "<@" + w.UserID + ">"
+		if _, ok := rg.Members[w.UserID]; ok {
+			mention += fmt.Sprintf(" (+%d)", len(w.Accounts))
+		} else if len(w.Accounts) > 1 {
+			mention += fmt.Sprintf(" (x%d)", len(w.Accounts))
+		}
+		mentions = append(mentions, mention)
+	}
+	return strings.Join(mentions, " ")
+}
+
+// fill the group's open spots from the waitlist, and tell whoever got in,
+// putting them in touch with hosts or remote players if it's time; must hold
+// the raid's lock
+func (bs *BotState) promoteWaitlist(out *deferred, rg *Group) {
+	for _, userID := range rg.promote(bs.limits(rg.raid.GuildID).GroupCap) {
+		bs.notify(out, rg.raid.ChannelID, map[string]int{userID: rg.Members[userID]},
+			fmt.Sprintf("a spot opened up: you're in group %d for the %s", rg.Number, rg.raid.String()))
+	}
+	bs.sendInvites(out, rg)
+}
+
+func (bs *BotState) groupCapCommand(s *discordgo.Session, m *discordgo.MessageCreate, query string) {
+	// usage:
+	//  - !groupcap - show the capacity
+	//  - !groupcap <n> - set how many accounts the server's groups take
+	//  - !groupcap <raid> <group> <n>|default - set it for one group
+	args := strings.Fields(query)
+	usage := "<@" + m.Author.ID + "> use `!groupcap <n>` or `!groupcap <raid> <group number> <n|default>`"
+	guildID := channelGuild(s, m.ChannelID)
+	switch {
+	case len(args) == 0:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> groups take up to %d accounts",
+			m.Author.ID, bs.limits(guildID).GroupCap))
+		return
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			s.ChannelMessageSend(m.ChannelID, usage)
+			return
+		}
+		if guildID == "" {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> set the group capacity from a channel on your server")
+			return
+		}
+		if !isModerator(s, m.Author.ID, m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> only moderators can change how many accounts groups take")
+			return
+		}
+		bs.mut.Lock()
+		if bs.GroupCaps == nil {
+			bs.GroupCaps = make(map[string]int)
+		}
+		bs.GroupCaps[guildID] = n
+		bs.recordSettings()
+		bs.mut.Unlock()
+
+		for _, raid := range bs.raidList() {
+			bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+				if raid.GuildID != guildID {
+					return
+				}
+				for _, rg := range raid.Groups {
+					if !rg.Expired {
+						bs.promoteWaitlist(out, rg)
+					}
+				}
+				bs.updatePost(out, raid)
+				bs.recordRaid(raid)
+			})
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> Got it! Groups take up to %d accounts",
+			m.Author.ID, n))
+		return
+	case len(args) == 2:
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+
+	bs.setGroupLimit(s, m, args, usage, func(out *deferred, rg *Group, n int) string {
+		if n < 0 {
+			n = 0
+		}
+		rg.Cap = n
+		bs.promoteWaitlist(out, rg)
+		return fmt.Sprintf("Group %d takes up to %d accounts",
+			rg.Number, rg.capacity(bs.limits(rg.raid.GuildID).GroupCap))
+	})
+}
+
+// change a limit of one group, from !groupcap or !remotecap <raid> <group>
+// <n>|default, if the user added the group or is a moderator. set is called
+// with n, or -1 for the guild's default, under the raid's lock, and returns
+// the reply.
+func (bs *BotState) setGroupLimit(s *discordgo.Session, m *discordgo.MessageCreate, args []string, usage string,
+	set func(out *deferred, rg *Group, n int) string) {
+	raid, err := bs.findRaid(strings.Join(args[:len(args)-2], " "))
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> "+err.Error())
+		return
+	}
+	group, err := strconv.Atoi(args[len(args)-2])
+	last := args[len(args)-1]
+	n, nerr := strconv.Atoi(last)
+	if last == "default" {
+		n, nerr = -1, nil
+	}
+	if err != nil || nerr != nil || (n < 0 && last != "default") {
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+	mod := isModerator(s, m.Author.ID, raid.ChannelID)
+	bs.withRaid(raid.MessageID, func(raid *Raid, out *deferred) {
+		rg := raid.group(group)
+		if rg == nil || rg.Expired {
+			out.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> the %s has no group %d",
+				m.Author.ID, raid.String(), group))
+			return
+		}
+		if rg.Creator != m.Author.ID && !mod {
+			out.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> only whoever added group %d or a moderator can change it",
+				m.Author.ID, group))
+			return
+		}
+		reply := set(out, rg, n)
+		bs.updatePost(out, raid)
+		bs.recordRaid(raid)
+		out.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> Got it! "+reply)
+	})
+}
@@ -0,0 +1,269 @@
+// Package s2 computes S2 geometry cell ids, the quadtree of cells on a cube
+// projected onto the earth that the game uses to place gyms and EX raids.
+// Only the handful of operations raidquaza needs are implemented, following
+// the reference implementation at https://github.com/google/s2geometry.
+package s2
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxLevel = 30
+
+	posBits = 2*MaxLevel + 1
+	maxSize = 1 << MaxLevel
+
+	swapMask   = 1
+	invertMask = 2
+)
+
+var (
+	// Hilbert curve position of each (i,j) quadrant, for each orientation
+	ijToPos = [4][4]uint64{
+		{0, 1, 3, 2}, // canonical order
+		{0, 3, 1, 2}, // axes swapped
+		{2, 3, 1, 0}, // bits inverted
+		{2, 1, 3, 0}, // swapped & inverted
+	}
+	// inverse of ijToPos
+	posToIJ = [4][4]int{
+		{0, 1, 3, 2},
+		{0, 2, 3, 1},
+		{3, 2, 0, 1},
+		{3, 1, 0, 2},
+	}
+	posToOrientation = [4]int{swapMask, 0, 0, invertMask | swapMask}
+)
+
+// CellID identifies a cell at any level from 0 (a cube face) to 30 (~1cm²)
+type CellID uint64
+
+// CellIDFromLatLng returns the leaf (level 30) cell containing a point
+func CellIDFromLatLng(lat, lng float64) CellID {
+	face, u, v := xyzToFaceUV(latLngToXYZ(lat, lng))
+	return cellIDFromFaceIJ(face, stToIJ(uvToST(u)), stToIJ(uvToST(v)))
+}
+
+// CellIDFromToken parses a cell token as returned by CellID.ToToken
+func CellIDFromToken(token string) (CellID, error) {
+	if token == "" || len(token) > 16 {
+		return 0, errors.New("bad S2 cell token")
+	}
+	if token == "X" || token == "x" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(token+strings.Repeat("0", 16-len(token)), 16, 64)
+	if err != nil {
+		return 0, errors.New("bad S2 cell token")
+	}
+	c := CellID(id)
+	if !c.IsValid() {
+		return 0, errors.New("bad S2 cell token")
+	}
+	return c, nil
+}
+
+func (c CellID) IsValid() bool {
+	return c.Face() < 6 && c.lsb()&0x1555555555555555 != 0
+}
+
+func (c CellID) Face() int {
+	return int(uint64(c) >> posBits)
+}
+
+// lowest set bit, which marks the level
+func (c CellID) lsb() uint64 {
+	return uint64(c) & -uint64(c)
+}
+
+func lsbForLevel(level int) uint64 {
+	return 1 << uint(2*(MaxLevel-level))
+}
+
+func (c CellID) Level() int {
+	level := MaxLevel
+	for lsb := c.lsb(); lsb > 1; lsb >>= 2 {
+		level--
+	}
+	return level
+}
+
+// Parent returns the cell at the given (lower or equal) level containing c
+func (c CellID) Parent(level int) CellID {
+	lsb := lsbForLevel(level)
+	return CellID((uint64(c) & -lsb) | lsb)
+}
+
+// Contains reports whether other is c or one of its descendants
+func (c CellID) Contains(other CellID) bool {
+	lsb := c.lsb()
+	return uint64(other) >= uint64(c)-(lsb-1) && uint64(other) <= uint64(c)+(lsb-1)
+}
+
+// ToToken returns the short hex form of the id used by most S2 tools
+func (c CellID) ToToken() string {
+	if c == 0 {
+		return "X"
+	}
+	return strings.TrimRight(fmt.Sprintf("%016x", uint64(c)), "0")
+}
+
+func (c CellID) String() string {
+	return c.ToToken()
+}
+
+// Neighbors returns the up to 8 cells of the same level sharing an edge or a
+// corner with c; cells at cube corners have only 7.
+func (c CellID) Neighbors() []CellID {
+	level := c.Level()
+	size := 1 << uint(MaxLevel-level)
+	face, i, j := c.faceIJ()
+	// lower left corner of the cell
+	i &= -size
+	j &= -size
+
+	var neighbors []CellID
+	seen := map[CellID]bool{c: true}
+	for di := -1; di <= 1; di++ {
+		for dj := -1; dj <= 1; dj++ {
+			if di == 0 && dj == 0 {
+				continue
+			}
+			n := cellIDFromFaceIJWrap(face, i+di*size, j+dj*size).Parent(level)
+			if !seen[n] {
+				seen[n] = true
+				neighbors = append(neighbors, n)
+			}
+		}
+	}
+	return neighbors
+}
+
+// LatLng returns the center of the cell in degrees
+func (c CellID) LatLng() (float64, float64) {
+	face, i, j := c.faceIJ()
+	size := 1 << uint(MaxLevel-c.Level())
+	i = i&-size + size/2
+	j = j&-size + size/2
+	x, y, z := faceUVToXYZ(face,
+		stToUV(float64(i)/maxSize), stToUV(float64(j)/maxSize))
+	return math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi, math.Atan2(y, x) * 180 / math.Pi
+}
+
+// face and leaf (i,j) coordinates of the first leaf cell in c
+func (c CellID) faceIJ() (int, int, int) {
+	face := c.Face()
+	id := uint64(c) - (c.lsb() - 1)
+	orientation := face & swapMask
+	i, j := 0, 0
+	for k := MaxLevel - 1; k >= 0; k-- {
+		pos := (id >> uint(2*k+1)) & 3
+		ij := posToIJ[orientation][pos]
+		i |= (ij >> 1) << uint(k)
+		j |= (ij & 1) << uint(k)
+		orientation ^= posToOrientation[pos]
+	}
+	return face, i, j
+}
+
+func cellIDFromFaceIJ(face, i, j int) CellID {
+	id := uint64(face)
+	orientation := face & swapMask
+	for k := MaxLevel - 1; k >= 0; k-- {
+		ij := ((i>>uint(k))&1)<<1 | (j>>uint(k))&1
+		pos := ijToPos[orientation][ij]
+		id = id<<2 | pos
+		orientation ^= posToOrientation[pos]
+	}
+	return CellID(id<<1 | 1)
+}
+
+// like cellIDFromFaceIJ, but (i,j) may be just off the face, in which case
+// the neighboring face's cell is returned
+func cellIDFromFaceIJWrap(face, i, j int) CellID {
+	if i >= 0 && i < maxSize && j >= 0 && j < maxSize {
+		return cellIDFromFaceIJ(face, i, j)
+	}
+	// project onto the extended face, then back onto the cube; the linear
+	// projection is accurate enough this close to the edge
+	clamp := func(x int) float64 {
+		x = int(math.Max(-1, math.Min(maxSize, float64(x))))
+		limit := math.Nextafter(1, 2)
+		return math.Max(-limit, math.Min(limit, float64(2*x+1-maxSize)/maxSize))
+	}
+	face, u, v := xyzToFaceUV(faceUVToXYZ(face, clamp(i), clamp(j)))
+	return cellIDFromFaceIJ(face, stToIJ(0.5*(u+1)), stToIJ(0.5*(v+1)))
+}
+
+func latLngToXYZ(lat, lng float64) (float64, float64, float64) {
+	phi := lat * math.Pi / 180
+	theta := lng * math.Pi / 180
+	return math.Cos(phi) * math.Cos(theta), math.Cos(phi) * math.Sin(theta), math.Sin(phi)
+}
+
+func xyzToFaceUV(x, y, z float64) (int, float64, float64) {
+	face := 0
+	ax, ay, az := math.Abs(x), math.Abs(y), math.Abs(z)
+	if ay > ax {
+		face = 1
+	}
+	if az > math.Max(ax, ay) {
+		face = 2
+	}
+	if [3]float64{x, y, z}[face] < 0 {
+		face += 3
+	}
+	switch face {
+	case 0:
+		return face, y / x, z / x
+	case 1:
+		return face, -x / y, z / y
+	case 2:
+		return face, -x / z, -y / z
+	case 3:
+		return face, z / x, y / x
+	case 4:
+		return face, z / y, -x / y
+	}
+	return face, -y / z, -x / z
+}
+
+func faceUVToXYZ(face int, u, v float64) (float64, float64, float64) {
+	switch face {
+	case 0:
+		return 1, u, v
+	case 1:
+		return -u, 1, v
+	case 2:
+		return -u, -v, 1
+	case 3:
+		return -1, -v, -u
+	case 4:
+		return v, -1, -u
+	}
+	return v, u, -1
+}
+
+// quadratic projection, which keeps cells close to the same area
+func uvToST(u float64) float64 {
+	if u >= 0 {
+		return 0.5 * math.Sqrt(1+3*u)
+	}
+	return 1 - 0.5*math.Sqrt(1-3*u)
+}
+
+func stToUV(s float64) float64 {
+	if s >= 0.5 {
+		return (1 / 3.) * (4*s*s - 1)
+	}
+	return (1 / 3.) * (1 - 4*(1-s)*(1-s))
+}
+
+func stToIJ(s float64) int {
+	return int(math.Max(0, math.Min(maxSize-1, math.Floor(maxSize*s))))
+}
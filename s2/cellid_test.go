@@ -0,0 +1,60 @@
+package s2
+
+import "testing"
+
+func TestCellIDFromLatLng(t *testing.T) {
+	// the center of face 0
+	c := CellIDFromLatLng(0, 0)
+	if c != 0x1000000000000001 {
+		t.Fatalf("%x", uint64(c))
+	}
+	for face, token := range []string{"1", "3", "5", "7", "9", "b"} {
+		f := CellID(uint64(face)<<posBits | 1<<(posBits-1))
+		if f.ToToken() != token || f.Level() != 0 || f.Face() != face {
+			t.Fatal(face, f.ToToken(), f.Level())
+		}
+	}
+
+	c = CellIDFromLatLng(37.6601, -121.8862)
+	for level := 0; level <= MaxLevel; level++ {
+		p := c.Parent(level)
+		if p.Level() != level || !p.Contains(c) || !p.IsValid() {
+			t.Fatal(level, p)
+		}
+		q, err := CellIDFromToken(p.ToToken())
+		if err != nil || q != p {
+			t.Fatal(level, p, q, err)
+		}
+		lat, lng := p.LatLng()
+		if level > 5 && CellIDFromLatLng(lat, lng).Parent(level) != p {
+			t.Fatal("center not in cell", level, lat, lng)
+		}
+	}
+	t.Log(c.Parent(14), c.Parent(17), c.Parent(20))
+}
+
+func TestCellID_Neighbors(t *testing.T) {
+	for _, c := range []CellID{
+		CellIDFromLatLng(37.6601, -121.8862).Parent(14),
+		CellIDFromLatLng(0, 45).Parent(10),    // on a face edge
+		CellIDFromLatLng(35.26, 45).Parent(3), // near a cube corner
+	} {
+		neighbors := c.Neighbors()
+		t.Log(c, neighbors)
+		if len(neighbors) < 7 {
+			t.Fatal(c, len(neighbors))
+		}
+		for _, n := range neighbors {
+			if n.Level() != c.Level() || n == c {
+				t.Fatal(c, n)
+			}
+			found := false
+			for _, nn := range n.Neighbors() {
+				found = found || nn == c
+			}
+			if !found {
+				t.Fatal(c, "not a neighbor of", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package store keeps state safe on disk: an append-only journal of
+// checksummed records synced as they're written, and atomic snapshot files
+// for compacting it.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Journal appends records to a file, one per line, each prefixed with the
+// CRC-32 of the record. Append doesn't return until the record is on disk.
+type Journal struct {
+	mut  sync.Mutex
+	path string
+	f    *os.File
+}
+
+// OpenJournal opens a journal for appending, creating it if needed; use
+// ReadJournal first to recover its records
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, f: f}, syncDir(path)
+}
+
+// Append writes a record, which must not contain newlines, and syncs it
+func (j *Journal) Append(rec []byte) error {
+	if bytes.IndexByte(rec, '\n') >= 0 {
+		return errors.New("journal records can't contain newlines")
+	}
+	line := make([]byte, 0, len(rec)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(rec))...)
+	line = append(line, rec...)
+	line = append(line, '\n')
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if j.f == nil {
+		return errors.New("journal is closed")
+	}
+	if _, err := j.f.Write(line); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Rotate moves the journal's records to oldPath, replacing any file there,
+// and starts it over empty
+func (j *Journal) Rotate(oldPath string) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	j.f = nil
+	if err := os.Rename(j.path, oldPath); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	return syncDir(j.path)
+}
+
+func (j *Journal) Close() error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// parse one journal line, without its newline
+func parseRecord(line []byte) ([]byte, error) {
+	if len(line) < 9 || line[8] != ' ' {
+		return nil, errors.New("malformed journal record")
+	}
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return nil, errors.New("malformed journal record")
+	}
+	rec := line[9:]
+	if crc32.ChecksumIEEE(rec) != uint32(sum) {
+		return nil, errors.New("journal record checksum mismatch")
+	}
+	return rec, nil
+}
+
+// ReadJournal calls fn with each record in the journal at path, in order. A
+// missing journal has no records. Reading stops at the first torn or corrupt
+// record, e.g. from a crash mid-write, and the journal is truncated there so
+// new records follow the good ones.
+func ReadJournal(path string, fn func(rec []byte) error) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var good int64 // offset just past the last good record
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("%s: dropping torn record at offset %d", path, good)
+				return truncate(f, good)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rec, perr := parseRecord(line[:len(line)-1])
+		if perr != nil {
+			log.Printf("%s: %s at offset %d; dropping the rest", path, perr, good)
+			return truncate(f, good)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+		good += int64(len(line))
+	}
+}
+
+func truncate(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// WriteFileAtomic replaces the file at path with data, so that after a crash
+// it holds either the old or the new contents
+func WriteFileAtomic(path string, data []byte) error {
+	tmpPath := path + "_tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// sync the directory holding path, so a new or renamed file survives a crash
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
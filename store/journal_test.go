@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func readAll(t *testing.T, path string) []string {
+	var recs []string
+	if err := ReadJournal(path, func(rec []byte) error {
+		recs = append(recs, string(rec))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestJournal(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "test.journal")
+
+	if recs := readAll(t, path); len(recs) != 0 {
+		t.Fatal(recs)
+	}
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []string{`{"a":1}`, `{"b":2}`, `{"c":3}`} {
+		if err := j.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Append([]byte("two\nlines")); err == nil {
+		t.Fatal("appended a newline")
+	}
+	j.Close()
+	if recs := readAll(t, path); len(recs) != 3 || recs[2] != `{"c":3}` {
+		t.Fatal(recs)
+	}
+
+	// a torn write at the end is dropped, and new records follow the good ones
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`0badf00d {"d":`)
+	f.Close()
+	if recs := readAll(t, path); len(recs) != 3 {
+		t.Fatal(recs)
+	}
+	j, _ = OpenJournal(path)
+	j.Append([]byte(`{"e":5}`))
+	j.Close()
+	if recs := readAll(t, path); len(recs) != 4 || recs[3] != `{"e":5}` {
+		t.Fatal(recs)
+	}
+
+	// so is everything from a record that fails its checksum
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-3] = 'X'
+	ioutil.WriteFile(path, data, 0644)
+	if recs := readAll(t, path); len(recs) != 3 {
+		t.Fatal(recs)
+	}
+}
+
+func TestJournal_Rotate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "test.journal")
+
+	j, _ := OpenJournal(path)
+	defer j.Close()
+	j.Append([]byte("1"))
+	if err := j.Rotate(path + ".old"); err != nil {
+		t.Fatal(err)
+	}
+	j.Append([]byte("2"))
+	if recs := readAll(t, path+".old"); len(recs) != 1 || recs[0] != "1" {
+		t.Fatal(recs)
+	}
+	if recs := readAll(t, path); len(recs) != 1 || recs[0] != "2" {
+		t.Fatal(recs)
+	}
+}
+
+// run by TestJournal_Crash in a child process, which appends numbered
+// records as fast as it can until it's killed
+func TestJournalCrashHelper(t *testing.T) {
+	path := os.Getenv("JOURNAL_CRASH_PATH")
+	if path == "" {
+		t.Skip("only run by TestJournal_Crash")
+	}
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if err := j.Append([]byte(fmt.Sprintf(`{"n":%d,"pad":"%0100d"}`, i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJournal_Crash(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "test.journal")
+
+	for round := 0; round < 3; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestJournalCrashHelper$")
+		cmd.Env = append(os.Environ(), "JOURNAL_CRASH_PATH="+path)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(50+round*20) * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		// every record that survived is intact and in order, each round
+		// starting over from 0
+		recs := readAll(t, path)
+		want := 0
+		for _, rec := range recs {
+			n, err := strconv.Atoi(rec[len(`{"n":`):strings.IndexByte(rec, ',')])
+			if err != nil {
+				t.Fatal(rec)
+			}
+			if n == 0 {
+				want = 0
+			}
+			if n != want {
+				t.Fatalf("record %d after %d", n, want-1)
+			}
+			want++
+		}
+		t.Logf("round %d: %d records", round, len(recs))
+	}
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Envelope wraps a versioned file's data, or heads a line-oriented file
+// with no Data
+type Envelope struct {
+	Schema  string          `json:"schema"`
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// a Migration upgrades data from one schema version to the next
+type Migration func(data []byte) ([]byte, error)
+
+// Schema is a versioned file format and the migrations upgrading its older
+// versions. Files from before versioning are version 1.
+type Schema struct {
+	Name    string
+	Version int // current version
+
+	migrations map[int]Migration // from version -> migration to version+1
+}
+
+func NewSchema(name string, version int) *Schema {
+	return &Schema{Name: name, Version: version, migrations: make(map[int]Migration)}
+}
+
+// Register adds the migration from version from to from+1
+func (s *Schema) Register(from int, m Migration) {
+	s.migrations[from] = m
+}
+
+// Upgrade migrates data from version to the current version
+func (s *Schema) Upgrade(version int, data []byte) ([]byte, error) {
+	if version > s.Version {
+		return nil, fmt.Errorf("%s version %d is newer than this program's %d", s.Name, version, s.Version)
+	}
+	for v := version; v < s.Version; v++ {
+		m, ok := s.migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration for %s version %d", s.Name, v)
+		}
+		var err error
+		data, err = m(data)
+		if err != nil {
+			return nil, fmt.Errorf("migrating %s version %d: %s", s.Name, v, err)
+		}
+	}
+	return data, nil
+}
+
+// Wrap puts data in an envelope of the current version
+func (s *Schema) Wrap(data []byte) ([]byte, error) {
+	return json.Marshal(Envelope{Schema: s.Name, Version: s.Version, Data: data})
+}
+
+// Header is the envelope heading a line-oriented file of the current version
+func (s *Schema) Header() []byte {
+	m, _ := json.Marshal(Envelope{Schema: s.Name, Version: s.Version})
+	return m
+}
+
+// Parse reads an envelope, returning its version. ok is false if it isn't
+// an envelope at all, e.g. a file from before versioning.
+func (s *Schema) Parse(m []byte) (env Envelope, ok bool, err error) {
+	if err := json.Unmarshal(m, &env); err != nil || env.Schema == "" {
+		return Envelope{}, false, nil
+	}
+	if env.Schema != s.Name {
+		return env, true, fmt.Errorf("expected %s, got %s", s.Name, env.Schema)
+	}
+	return env, true, nil
+}
+
+// Unwrap returns the data in a file written by Wrap, upgraded to the current
+// version, and the version it was written in
+func (s *Schema) Unwrap(file []byte) ([]byte, int, error) {
+	env, ok, err := s.Parse(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	version, data := 1, file
+	if ok {
+		version, data = env.Version, env.Data
+	}
+	data, err = s.Upgrade(version, data)
+	return data, version, err
+}
+
+// BackupPath is where Backup keeps a copy of path from the given version
+func BackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// Backup copies the file at path aside before it's rewritten in a newer
+// version, unless there's already a backup of that version
+func Backup(path string, version int) error {
+	bak := BackupPath(path, version)
+	if _, err := os.Stat(bak); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return WriteFileAtomic(bak, data)
+}
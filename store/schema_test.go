@@ -0,0 +1,33 @@
+package store
+
+import "testing"
+
+func TestSchema_Unwrap(t *testing.T) {
+	s := NewSchema("test", 3)
+	s.Register(1, func(data []byte) ([]byte, error) {
+		return []byte(`{"v2":` + string(data) + `}`), nil
+	})
+	s.Register(2, func(data []byte) ([]byte, error) {
+		return []byte(`{"v3":` + string(data) + `}`), nil
+	})
+
+	data, version, err := s.Unwrap([]byte(`{"a":1}`))
+	if err != nil || version != 1 || string(data) != `{"v3":{"v2":{"a":1}}}` {
+		t.Fatal(string(data), version, err)
+	}
+	data, version, err = s.Unwrap([]byte(`{"schema":"test","version":2,"data":{"a":1}}`))
+	if err != nil || version != 2 || string(data) != `{"v3":{"a":1}}` {
+		t.Fatal(string(data), version, err)
+	}
+	wrapped, _ := s.Wrap([]byte(`{"a":1}`))
+	data, version, err = s.Unwrap(wrapped)
+	if err != nil || version != 3 || string(data) != `{"a":1}` {
+		t.Fatal(string(data), version, err)
+	}
+	if _, _, err := s.Unwrap([]byte(`{"schema":"other","version":1}`)); err == nil {
+		t.Fatal("unwrapped another schema")
+	}
+	if _, _, err := s.Unwrap([]byte(`{"schema":"test","version":4}`)); err == nil {
+		t.Fatal("unwrapped a newer version")
+	}
+}
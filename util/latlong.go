@@ -1,6 +1,7 @@
 package util
 
 import (
+	"math"
 	"strconv"
 	"strings"
 	"errors"
@@ -38,3 +39,16 @@ func ParseLatLong(latlon []string) (float64, float64, int, error) {
 	}
 	return lat, lon, nConsumed, nil
 }
+
+const earthRadius = 6371008.8 // mean earth radius, meters
+
+// Distance returns the great-circle distance in meters between two lat/lon
+// points, in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -36,3 +36,15 @@ func TestParseLatLong(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDistance(t *testing.T) {
+	// two points about 50m apart
+	d := Distance(37.701695, -121.899179, 37.702151, -121.899272)
+	t.Log(d)
+	if d < 45 || d > 55 {
+		t.Fail()
+	}
+	if Distance(37.7, -121.9, 37.7, -121.9) != 0 {
+		t.Fail()
+	}
+}